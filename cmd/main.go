@@ -5,8 +5,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"tdx-init/pkg/config"
 	"tdx-init/pkg/setup"
+	"tdx-init/pkg/version"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
@@ -20,6 +25,7 @@ var rootCmd = &cobra.Command{
 	Long: `A configurable CLI tool for secure disk encryption and SSH key management
 in TDX (Trusted Domain Extensions) environments. Provides flexible strategies
 for key initialization, passphrase generation, and disk selection.`,
+	Version: version.Version,
 }
 
 var setupCmd = &cobra.Command{
@@ -36,6 +42,32 @@ This includes disk encryption, SSH key management, and persistent storage setup.
 	},
 }
 
+var teardownCmd = &cobra.Command{
+	Use:   "teardown [config]",
+	Short: "Release disks at shutdown",
+	Long: `Disables encrypted swap, unmounts the managed filesystems and closes their
+encrypted mappings. Meant to run at shutdown, after the services using the
+disks have stopped.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			configFile = args[0]
+		}
+		runTeardown()
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of the managed disks",
+	Long: `Shows the outcome of the last setup run of this boot: the device, mapping and
+mount point of every disk, and the result of its filesystem check.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		showStatus()
+	},
+}
+
 var validateCmd = &cobra.Command{
 	Use:   "validate [config]",
 	Short: "Validate configuration file",
@@ -51,6 +83,8 @@ var validateCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(setupCmd)
+	rootCmd.AddCommand(teardownCmd)
+	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(validateCmd)
 	rootCmd.AddCommand(generateConfigCmd)
 }
@@ -82,12 +116,71 @@ func runSetup() {
 		log.Fatalf("Failed to create orchestrator: %v", err)
 	}
 
-	ctx := context.Background()
+	// Cancel waits for devices and keys when the service is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	if err := orchestrator.Setup(ctx); err != nil {
 		log.Fatalf("Setup failed: %v", err)
 	}
 }
 
+func runTeardown() {
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	orchestrator, err := setup.NewOrchestrator(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create orchestrator: %v", err)
+	}
+
+	if err := orchestrator.Teardown(); err != nil {
+		log.Fatalf("Teardown failed: %v", err)
+	}
+}
+
+func showStatus() {
+	status, err := setup.ReadStatus()
+	if err != nil {
+		log.Fatalf("No status available: %v", err)
+	}
+
+	fmt.Printf("Last setup: %s\n", status.UpdatedAt.Local().Format(time.RFC1123))
+	if status.Error != "" {
+		fmt.Printf("Setup failed: %s\n", status.Error)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DISK\tDEVICE\tMAPPER\tMOUNT POINT\tMOUNTED\tFSCK")
+	for _, disk := range status.Disks {
+		mounted := "no"
+		if disk.Mounted {
+			mounted = "yes"
+			if disk.ReadOnly {
+				mounted = "read-only"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", disk.Name, orDash(disk.Device), orDash(disk.Mapper), orDash(disk.MountPoint), mounted, orDash(disk.Fsck))
+	}
+	w.Flush()
+
+	for _, disk := range status.Disks {
+		for _, warning := range disk.HeaderWarnings {
+			fmt.Printf("Warning: LUKS header of %s: %s\n", disk.Name, warning)
+		}
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
 func validateConfig() {
 	cfg, err := config.LoadConfig(configFile)
 	if err != nil {
@@ -148,16 +241,47 @@ disks:
   # Define one or more disks to manage
   disk_persistent:
     # Strategy for finding the disk
-    strategy: "largest"  # Options: 'largest', 'pathglob'
+    strategy: "largest"  # Options: 'largest', 'pathglob', 'by_id', 'sysfs', 'file'
     
     # For 'pathglob' strategy, specify the pattern:
     # strategy_config:
     #   path_glob: "/dev/sd*"
     
+    # For 'by_id' strategy, specify one or more stable identifiers.
+    # Exactly one device must match all of them:
+    # strategy_config:
+    #   serial: "S4EWNX0R123456"
+    #   wwn: "0x5002538e40a1b2c3"
+    #   id: "nvme-Samsung_SSD_970_EVO_1TB_S4EWNX0R123456"
+    #   partuuid: "0fc63daf-8483-4772-8e79-3d69d8477de4"
+    
+    # For 'sysfs' strategy, filter disks by their sysfs attributes
+    # (all filters are optional; boot and read-only disks are skipped):
+    # strategy_config:
+    #   transport: "nvme"     # 'nvme', 'virtio', 'scsi', 'sata', 'usb', 'mmc', 'xen'
+    #   model: "Samsung*"     # Glob pattern, case-insensitive
+    #   vendor: "QEMU*"       # Glob pattern, case-insensitive
+    #   rotational: false
+    #   removable: false
+    #   min_size: "100G"
+    #   max_size: "2T"
+    #   select: "largest"     # 'largest', 'smallest', or 'first'
+    
+    # For 'file' strategy, keep the disk in a sparse image file attached
+    # through a loop device. The file is created on first boot and grown
+    # (never shrunk) when size increases; teardown detaches it:
+    # strategy_config:
+    #   path: "/var/lib/tdx-init/persistent.img"
+    #   size: "20G"
+    
     # When to format the disk
     # - 'always': Format on every run (DESTRUCTIVE!)
-    # - 'on_initialize': Format only if not already initialized (default)
+    # - 'on_initialize': Format only if not already initialized (default);
+    #   an interrupted first setup is resumed, not started over
     # - 'never': Never format, only mount existing filesystems
+    # - 'ephemeral': Encrypt with a random key generated on every boot and
+    #   never stored, then format (for caches and scratch data; leave
+    #   encryption_key empty)
     format: "on_initialize"
     
     # Encryption key to use (references a key from 'keys' section)
@@ -166,6 +290,106 @@ disks:
     
     # Where to mount the disk
     mount_at: "/persistent"
+    
+    # Wait for the device to appear, e.g. when disks are hot-attached after
+    # boot (optional; by default the lookup fails right away).
+    # wait_for_device:
+    #   timeout: "60s"
+    #   mode: "uevent"            # 'poll' (default) or 'uevent'
+    #   interval: "1s"            # Retry interval (default: 1s)
+    
+    # Disks to set up before this one (optional). Disks mounted inside
+    # another disk's mount point are ordered after it automatically.
+    # depends_on: ["disk_other"]
+    
+    # Filesystem to create: 'ext4' (default), 'xfs', or 'btrfs'
+    # filesystem: "ext4"
+    
+    # Extra arguments passed to mkfs (optional)
+    # mkfs_options: ["-m", "0"]
+    
+    # Mount options, applied through mount(2) (optional)
+    # mount_options: ["nosuid", "nodev", "noexec", "discard"]
+    
+    # Filesystem check before mounting an existing disk (optional):
+    # 'none' (default), 'check' (read-only check) or 'repair' (fix what the
+    # checker can fix unattended; btrfs is only checked). When errors remain,
+    # fsck_on_error decides: 'refuse' (default) to mount, or 'read_only'.
+    # The outcome is shown by 'tdx-init status'. A disk whose checker is not
+    # installed is mounted unchecked and shown as 'unavailable'.
+    # fsck: "check"
+    # fsck_on_error: "read_only"
+    
+    # Grow the LUKS container and filesystem into space added to the device,
+    # e.g. after resizing a cloud volume or the image file (optional; not
+    # for swap, ephemeral, partitioned or integrity disks). The new size is
+    # recorded in the init token.
+    # auto_grow: true
+    
+    # Filesystem label (optional; partitions are labeled after themselves)
+    # label: "persistent"
+    
+    # Directories kept below the mount point, applied on every boot
+    # (optional). Defaults to 'ssh', 'data' and 'logs' (mode 0700, root) on
+    # encrypted disks and 'data' and 'logs' on plain disks; an empty list
+    # creates none.
+    # directories:
+    #   - path: "data"
+    #     mode: "0750"
+    #     owner: "app"              # Name or numeric ID
+    #     group: "app"
+    #     selinux_context: "system_u:object_r:container_file_t:s0"
+    #   - path: "logs"
+    
+    # Directories of the root filesystem to keep on this disk (optional).
+    # Each source below the mount point is bind-mounted over its target
+    # after all disks and overlays are set up and before SSH, so a target
+    # may lie inside an overlay target (here /var). On first boot the source
+    # is seeded with the target's existing contents.
+    # persist:
+    #   - source: "persist/docker"
+    #     target: "/var/lib/docker"
+    #   - source: "persist/home"
+    #     target: "/home"
+    
+    # LUKS2 parameters (optional; unset values keep cryptsetup defaults).
+    # The header is checked against these on every boot.
+    # luks:
+    #   cipher: "aes-xts-plain64"
+    #   key_size: 512             # Bits
+    #   hash: "sha256"
+    #   pbkdf: "argon2id"         # 'argon2id' or 'pbkdf2'
+    #   pbkdf_memory: 1048576     # KiB (argon2id only)
+    #   pbkdf_time: 4             # Time cost (iterations for pbkdf2)
+    #   pbkdf_parallel: 4         # Threads (argon2id only)
+    #   sector_size: 4096
+    #   label: "tdx-persistent"
+    #   subsystem: "tdx-init"
+    #   on_mismatch: "warn"       # 'warn' or 'error'
+    
+    # Authenticated encryption through dm-integrity (optional).
+    # Options: 'hmac-sha256', 'hmac-sha512', 'aead'. The initial wipe writes
+    # the whole disk once and resumes from its last checkpoint if interrupted.
+    # On partitioned disks every partition must have an encryption_key.
+    # integrity: "hmac-sha256"
+
+    # Detached LUKS header (optional). The data disk then carries no LUKS
+    # signature; the header file holds the keyslots and tokens. With backup
+    # set, a copy is refreshed on every boot and used to restore a missing
+    # or damaged detached header.
+    # header:
+    #   path: "/boot/tdx-persistent.header"
+    #   backup: "/boot/tdx-persistent.header.bak"
+
+    # Identity checks (optional). An existing container whose init token
+    # names another disk entry is never opened or formatted for this one,
+    # nor overwritten by a swap or ephemeral disk. With uuid set, the
+    # container is also created with, and required to have, that LUKS UUID.
+    # on_mismatch: 'refuse' (default), 'adopt' (take the disk over with its
+    # data) or 'force' (also allow formatting it).
+    # identity:
+    #   uuid: "6f1c2d4e-8a3b-4c5d-9e0f-1a2b3c4d5e6f"
+    #   on_mismatch: "refuse"
 
   # Example of an additional unencrypted disk:
   # disk_data:
@@ -174,6 +398,56 @@ disks:
   #     path_glob: "/dev/nvme*"
   #   format: "on_initialize"
   #   mount_at: "/data"
+
+  # Example of a disk split into GPT partitions. Partitions are referenced by
+  # label and each one may use its own encryption key and mount point. The
+  # layout is verified on later boots and never rewritten unless format is
+  # 'always'. A device without a partition table that holds a filesystem or
+  # LUKS container is only partitioned with format 'always'. Sizes are fixed
+  # ("20G"), a percentage ("25%"), or "rest".
+  # disk_split:
+  #   strategy: "sysfs"
+  #   strategy_config:
+  #     transport: "nvme"
+  #   format: "on_initialize"
+  #   partitions:
+  #     - label: "state"
+  #       size: "20G"
+  #       encryption_key: "key_persistent"
+  #       mount_at: "/state"
+  #     - label: "scratch"
+  #       size: "rest"
+  #       mount_at: "/scratch"
+
+  # Example of encrypted swap. Swap always uses an ephemeral key, so nothing
+  # paged out survives a reboot. 'tdx-init teardown' disables it at shutdown.
+  # disk_swap:
+  #   strategy: "by_id"
+  #   strategy_config:
+  #     partuuid: "0f8a2c3e-5b1d-4e6f-9a7c-2d4b6e8f1a3c"
+  #   role: "swap"
+  #   swap_priority: 10         # -1 to 32767; omit to let the kernel decide
+
+# Setup tuning (optional)
+# setup:
+#   # Independent disks formatted and opened at the same time (default: 4).
+#   # Disks still wait for the disks they depend on.
+#   concurrency: 4
+
+# Overlays (optional): make directories of a read-only root image writable,
+# keeping the changes on a managed disk. The lower layer is the directory as
+# shipped in the image; upper and work directories live under 'dir' below
+# the disk's mount point (default: overlays/<target>). 'reset: true'
+# discards the changes on every boot. Overlays are mounted in order after
+# the disks, and hide whatever is mounted below their target: no disk may be
+# mounted at or below a target, and nested targets list the outer one first.
+# overlays:
+#   - target: "/etc"
+#     disk: "disk_persistent"
+#   - target: "/var"
+#     disk: "disk_persistent"
+#     dir: "overlays/var"
+#     reset: false
 `
 
 	filename := "config.example.yaml"
@@ -1,16 +1,41 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	SSH   SSHConfig            `yaml:"ssh"`
-	Keys  map[string]KeyConfig `yaml:"keys"`
-	Disks map[string]DiskConfig `yaml:"disks"`
+	SSH      SSHConfig             `yaml:"ssh"`
+	Keys     map[string]KeyConfig  `yaml:"keys"`
+	Disks    map[string]DiskConfig `yaml:"disks"`
+	Overlays []OverlayConfig       `yaml:"overlays"`
+	Setup    SetupConfig           `yaml:"setup"`
+}
+
+// SetupConfig tunes how disks are set up. Concurrency is the number of
+// independent disks that are formatted and opened at the same time.
+type SetupConfig struct {
+	Concurrency int `yaml:"concurrency"`
+}
+
+// OverlayConfig makes Target writable with overlayfs. The lower layer is
+// Target as shipped in the root image; the upper and work directories live
+// in Dir below the mount point of Disk. Reset discards the upper layer on
+// every boot.
+type OverlayConfig struct {
+	Target string `yaml:"target"`
+	Disk   string `yaml:"disk"`
+	Dir    string `yaml:"dir"`
+	Reset  bool   `yaml:"reset"`
 }
 
 type SSHConfig struct {
@@ -28,11 +53,134 @@ type KeyConfig struct {
 }
 
 type DiskConfig struct {
-	Strategy      string                 `yaml:"strategy"`
+	Strategy       string                 `yaml:"strategy"`
 	StrategyConfig map[string]interface{} `yaml:"strategy_config"`
-	Format        string                 `yaml:"format"`
-	EncryptionKey string                 `yaml:"encryption_key"`
-	MountAt       string                 `yaml:"mount_at"`
+	Format         string                 `yaml:"format"`
+	EncryptionKey  string                 `yaml:"encryption_key"`
+	MountAt        string                 `yaml:"mount_at"`
+	Partitions     []PartitionConfig      `yaml:"partitions"`
+	Filesystem     string                 `yaml:"filesystem"`
+	MkfsOptions    []string               `yaml:"mkfs_options"`
+	MountOptions   []string               `yaml:"mount_options"`
+	Label          string                 `yaml:"label"`
+	Luks           LuksConfig             `yaml:"luks"`
+	Integrity      string                 `yaml:"integrity"`
+	Header         HeaderConfig           `yaml:"header"`
+	Role           string                 `yaml:"role"`
+	SwapPriority   *int                   `yaml:"swap_priority"`
+	Directories    []DirectoryConfig      `yaml:"directories"`
+	Persist        []PersistConfig        `yaml:"persist"`
+	DependsOn      []string               `yaml:"depends_on"`
+	WaitForDevice  WaitConfig             `yaml:"wait_for_device"`
+	Fsck           string                 `yaml:"fsck"`
+	FsckOnError    string                 `yaml:"fsck_on_error"`
+	AutoGrow       bool                   `yaml:"auto_grow"`
+	Identity       IdentityConfig         `yaml:"identity"`
+}
+
+// IdentityConfig decides what happens when an existing LUKS container was
+// initialized for another disk entry, or does not have the pinned UUID.
+// "refuse" stops the setup of the disk, "adopt" takes the disk over with
+// its data, and "force" also allows formatting it.
+type IdentityConfig struct {
+	UUID       string `yaml:"uuid"`
+	OnMismatch string `yaml:"on_mismatch"`
+}
+
+// WaitConfig makes device lookup retry until a matching device appears or
+// Timeout passes. Mode is "poll", which retries every Interval, or "uevent",
+// which retries when the kernel announces a new block device.
+type WaitConfig struct {
+	Timeout  time.Duration `yaml:"timeout"`
+	Mode     string        `yaml:"mode"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// PersistConfig bind-mounts Source, a directory below the mount point of
+// the disk, over Target in the root filesystem.
+type PersistConfig struct {
+	Source string `yaml:"source"`
+	Target string `yaml:"target"`
+}
+
+// DirectoryConfig describes a directory kept under the mount point of a
+// disk. Mode is an octal string; Owner and Group are names or numeric IDs.
+type DirectoryConfig struct {
+	Path    string `yaml:"path"`
+	Mode    string `yaml:"mode"`
+	Owner   string `yaml:"owner"`
+	Group   string `yaml:"group"`
+	SELinux string `yaml:"selinux_context"`
+}
+
+type HeaderConfig struct {
+	Path   string `yaml:"path"`
+	Backup string `yaml:"backup"`
+}
+
+type LuksConfig struct {
+	Cipher        string `yaml:"cipher"`
+	KeySize       int    `yaml:"key_size"`
+	Hash          string `yaml:"hash"`
+	PBKDF         string `yaml:"pbkdf"`
+	PBKDFMemory   int    `yaml:"pbkdf_memory"`
+	PBKDFTime     int    `yaml:"pbkdf_time"`
+	PBKDFParallel int    `yaml:"pbkdf_parallel"`
+	SectorSize    int    `yaml:"sector_size"`
+	Label         string `yaml:"label"`
+	Subsystem     string `yaml:"subsystem"`
+	OnMismatch    string `yaml:"on_mismatch"`
+}
+
+type PartitionConfig struct {
+	Label         string `yaml:"label"`
+	Size          string `yaml:"size"`
+	EncryptionKey string `yaml:"encryption_key"`
+	MountAt       string `yaml:"mount_at"`
+}
+
+// PartitionSize is a parsed partition size. Exactly one of Bytes and Percent
+// is set, or neither when the partition takes the remaining space.
+type PartitionSize struct {
+	Bytes   int64
+	Percent int
+}
+
+var filesystemLabelLimits = map[string]int{
+	"ext4":  16,
+	"xfs":   12,
+	"btrfs": 255,
+}
+
+var genericMountOptions = []string{
+	"ro", "rw", "nosuid", "suid", "nodev", "dev", "noexec", "exec",
+	"sync", "async", "dirsync", "noatime", "atime", "nodiratime", "diratime",
+	"relatime", "norelatime", "strictatime", "lazytime", "nolazytime",
+	"discard", "nodiscard",
+}
+
+var filesystemMountOptions = map[string][]string{
+	"ext4": {
+		"acl", "noacl", "user_xattr", "nouser_xattr", "commit", "errors", "data",
+		"barrier", "nobarrier", "journal_checksum", "nojournal_checksum",
+		"auto_da_alloc", "noauto_da_alloc", "delalloc", "nodelalloc",
+		"init_itable", "noinit_itable", "dioread_lock", "dioread_nolock",
+		"max_batch_time", "min_batch_time", "stripe", "i_version", "nombcache",
+		"quota", "noquota", "usrquota", "grpquota", "prjquota",
+	},
+	"xfs": {
+		"allocsize", "attr2", "noattr2", "dax", "filestreams", "ikeep", "noikeep",
+		"inode32", "inode64", "largeio", "nolargeio", "logbufs", "logbsize",
+		"noalign", "norecovery", "nouuid", "wsync", "sunit", "swidth", "swalloc",
+		"quota", "noquota", "uquota", "usrquota", "gquota", "grpquota", "pquota", "prjquota",
+	},
+	"btrfs": {
+		"acl", "noacl", "autodefrag", "noautodefrag", "barrier", "nobarrier",
+		"commit", "compress", "compress-force", "datacow", "nodatacow",
+		"datasum", "nodatasum", "degraded", "flushoncommit", "noflushoncommit",
+		"max_inline", "space_cache", "nospace_cache", "ssd", "ssd_spread", "nossd",
+		"subvol", "subvolid", "thread_pool", "user_subvol_rm_allowed",
+	},
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -53,6 +201,18 @@ func LoadConfig(path string) (*Config, error) {
 	return &config, nil
 }
 
+// Hash returns a digest of a validated disk configuration. It is recorded
+// when the disk is initialized, so that a later configuration can be
+// compared with the one the disk was set up with.
+func (d DiskConfig) Hash() string {
+	data, err := yaml.Marshal(d)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
 func (c *Config) Validate() error {
 	if c.SSH.Strategy == "" {
 		return fmt.Errorf("ssh.strategy is required")
@@ -64,6 +224,13 @@ func (c *Config) Validate() error {
 		c.SSH.KeyPath = "/etc/root_key"
 	}
 
+	if c.Setup.Concurrency == 0 {
+		c.Setup.Concurrency = 4
+	}
+	if c.Setup.Concurrency < 0 {
+		return fmt.Errorf("setup.concurrency must be positive")
+	}
+
 	for name, key := range c.Keys {
 		if key.Strategy == "" {
 			return fmt.Errorf("keys.%s.strategy is required", name)
@@ -77,25 +244,183 @@ func (c *Config) Validate() error {
 		if disk.Strategy == "" {
 			return fmt.Errorf("disks.%s.strategy is required", name)
 		}
-		if disk.Strategy != "largest" && disk.Strategy != "pathglob" {
-			return fmt.Errorf("disks.%s.strategy must be 'largest' or 'pathglob'", name)
+		switch disk.Strategy {
+		case "largest", "pathglob":
+		case "by_id":
+			if err := validateByIDConfig(disk.StrategyConfig); err != nil {
+				return fmt.Errorf("disks.%s.strategy_config: %w", name, err)
+			}
+		case "sysfs":
+			if err := validateSysfsConfig(disk.StrategyConfig); err != nil {
+				return fmt.Errorf("disks.%s.strategy_config: %w", name, err)
+			}
+		case "file":
+			if err := validateFileConfig(disk.StrategyConfig); err != nil {
+				return fmt.Errorf("disks.%s.strategy_config: %w", name, err)
+			}
+		default:
+			return fmt.Errorf("disks.%s.strategy must be 'largest', 'pathglob', 'by_id', 'sysfs', or 'file'", name)
+		}
+		switch disk.Role {
+		case "", "filesystem":
+			if disk.SwapPriority != nil {
+				return fmt.Errorf("disks.%s.swap_priority requires role 'swap'", name)
+			}
+		case "swap":
+			if disk.Format == "" {
+				disk.Format = "ephemeral"
+			}
+			if err := validateSwap(disk); err != nil {
+				return fmt.Errorf("disks.%s: %w", name, err)
+			}
+		default:
+			return fmt.Errorf("disks.%s.role must be 'filesystem' or 'swap'", name)
+		}
+		if err := validateWait(&disk.WaitForDevice); err != nil {
+			return fmt.Errorf("disks.%s.wait_for_device: %w", name, err)
 		}
 		if disk.Format == "" {
 			disk.Format = "on_initialize"
 		}
-		if disk.Format != "always" && disk.Format != "on_initialize" && disk.Format != "never" {
-			return fmt.Errorf("disks.%s.format must be 'always', 'on_initialize', or 'never'", name)
+		if disk.Format != "always" && disk.Format != "on_initialize" && disk.Format != "never" && disk.Format != "ephemeral" {
+			return fmt.Errorf("disks.%s.format must be 'always', 'on_initialize', 'never', or 'ephemeral'", name)
+		}
+		if disk.Format == "ephemeral" {
+			if err := validateEphemeral(disk); err != nil {
+				return fmt.Errorf("disks.%s: %w", name, err)
+			}
+		}
+		// Swap has no filesystem, and defaulting one would make the swap
+		// check fail when the config is validated again
+		if disk.Role != "swap" {
+			if disk.Filesystem == "" {
+				disk.Filesystem = "ext4"
+			}
+			if err := validateFilesystem(disk); err != nil {
+				return fmt.Errorf("disks.%s: %w", name, err)
+			}
+		}
+		if disk.Fsck == "" {
+			disk.Fsck = "none"
+		}
+		if disk.Fsck != "none" && disk.Fsck != "check" && disk.Fsck != "repair" {
+			return fmt.Errorf("disks.%s.fsck must be 'none', 'check', or 'repair'", name)
+		}
+		if disk.FsckOnError == "" {
+			disk.FsckOnError = "refuse"
+		}
+		if disk.FsckOnError != "refuse" && disk.FsckOnError != "read_only" {
+			return fmt.Errorf("disks.%s.fsck_on_error must be 'refuse' or 'read_only'", name)
+		}
+		if disk.Luks.OnMismatch == "" {
+			disk.Luks.OnMismatch = "warn"
+		}
+		if err := validateLuks(disk.Luks); err != nil {
+			return fmt.Errorf("disks.%s.luks: %w", name, err)
 		}
-		if disk.MountAt == "" {
+		switch disk.Integrity {
+		case "", "hmac-sha256", "hmac-sha512", "aead":
+		default:
+			return fmt.Errorf("disks.%s.integrity must be 'hmac-sha256', 'hmac-sha512', or 'aead'", name)
+		}
+		if disk.Integrity != "" && disk.EncryptionKey == "" && len(disk.Partitions) == 0 {
+			return fmt.Errorf("disks.%s.integrity requires encryption_key", name)
+		}
+		// Partitions inherit integrity, which only applies to encrypted ones
+		for _, part := range disk.Partitions {
+			if disk.Integrity != "" && part.EncryptionKey == "" {
+				return fmt.Errorf("disks.%s.integrity requires encryption_key on every partition, partition %s has none", name, part.Label)
+			}
+		}
+		if err := validateIdentity(&disk.Identity, disk); err != nil {
+			return fmt.Errorf("disks.%s.identity: %w", name, err)
+		}
+		if disk.Header.Path != "" || disk.Header.Backup != "" {
+			if disk.EncryptionKey == "" {
+				return fmt.Errorf("disks.%s.header requires encryption_key", name)
+			}
+			if disk.Header.Path != "" && disk.Header.Path == disk.Header.Backup {
+				return fmt.Errorf("disks.%s.header.backup must differ from header.path", name)
+			}
+		}
+		if len(disk.Partitions) > 0 {
+			if disk.MountAt != "" || disk.EncryptionKey != "" {
+				return fmt.Errorf("disks.%s: mount_at and encryption_key must be set per partition when partitions are defined", name)
+			}
+			if err := validatePartitions(disk.Partitions); err != nil {
+				return fmt.Errorf("disks.%s.partitions: %w", name, err)
+			}
+		} else if disk.MountAt == "" && disk.Role != "swap" {
 			return fmt.Errorf("disks.%s.mount_at is required", name)
 		}
+		if len(disk.Directories) > 0 && disk.Role == "swap" {
+			return fmt.Errorf("disks.%s.directories do not apply to swap disks", name)
+		}
+		if err := validateDirectories(disk.Directories); err != nil {
+			return fmt.Errorf("disks.%s.directories: %w", name, err)
+		}
+		if len(disk.Persist) > 0 && (disk.Role == "swap" || len(disk.Partitions) > 0) {
+			return fmt.Errorf("disks.%s.persist is not supported on swap or partitioned disks", name)
+		}
+		if err := validatePersist(disk.Persist); err != nil {
+			return fmt.Errorf("disks.%s.persist: %w", name, err)
+		}
+		if disk.AutoGrow {
+			if disk.Role == "swap" || disk.Format == "ephemeral" || len(disk.Partitions) > 0 {
+				return fmt.Errorf("disks.%s.auto_grow is not supported on swap, ephemeral or partitioned disks", name)
+			}
+			if disk.Integrity != "" {
+				return fmt.Errorf("disks.%s.auto_grow cannot be combined with integrity", name)
+			}
+		}
 		c.Disks[name] = disk
 	}
 
+	mountPoints := make(map[string]string)
+	for name, disk := range c.Disks {
+		for _, mp := range diskMountPoints(disk) {
+			if other, ok := mountPoints[mp]; ok {
+				return fmt.Errorf("disks.%s: mount point %s is also used by disk %s", name, mp, other)
+			}
+			mountPoints[mp] = name
+		}
+	}
+
+	if _, err := c.DiskOrder(); err != nil {
+		return err
+	}
+
+	targets := make(map[string]string)
+	for name, disk := range c.Disks {
+		for _, persist := range disk.Persist {
+			if other, ok := targets[persist.Target]; ok {
+				return fmt.Errorf("disks.%s.persist: %s is already persisted by disk %s", name, persist.Target, other)
+			}
+			targets[persist.Target] = name
+		}
+	}
+
+	for i := range c.Overlays {
+		overlay := &c.Overlays[i]
+		if err := validateOverlay(overlay, c.Disks); err != nil {
+			return fmt.Errorf("overlays[%d]: %w", i, err)
+		}
+		if other, ok := targets[overlay.Target]; ok {
+			return fmt.Errorf("overlays[%d]: %s is already used by disk %s", i, overlay.Target, other)
+		}
+		targets[overlay.Target] = overlay.Disk
+	}
+	if err := c.validateOverlayNesting(); err != nil {
+		return err
+	}
+
 	if c.SSH.StoreAt != "" {
 		if _, ok := c.Disks[c.SSH.StoreAt]; !ok {
 			return fmt.Errorf("ssh.store_at references non-existent disk '%s'", c.SSH.StoreAt)
 		}
+		if c.Disks[c.SSH.StoreAt].Format == "ephemeral" {
+			return fmt.Errorf("ssh.store_at cannot reference ephemeral disk '%s'", c.SSH.StoreAt)
+		}
 	}
 
 	for name, disk := range c.Disks {
@@ -104,7 +429,504 @@ func (c *Config) Validate() error {
 				return fmt.Errorf("disks.%s.encryption_key references non-existent key '%s'", name, disk.EncryptionKey)
 			}
 		}
+		for _, part := range disk.Partitions {
+			if part.EncryptionKey != "" {
+				if _, ok := c.Keys[part.EncryptionKey]; !ok {
+					return fmt.Errorf("disks.%s.partitions.%s.encryption_key references non-existent key '%s'", name, part.Label, part.EncryptionKey)
+				}
+			}
+		}
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+func validateFilesystem(disk DiskConfig) error {
+	limit, ok := filesystemLabelLimits[disk.Filesystem]
+	if !ok {
+		return fmt.Errorf("filesystem must be 'ext4', 'xfs', or 'btrfs'")
+	}
+	if len(disk.Label) > limit {
+		return fmt.Errorf("label must be at most %d characters for %s", limit, disk.Filesystem)
+	}
+	for _, part := range disk.Partitions {
+		if len(part.Label) > limit {
+			return fmt.Errorf("partition label %s is used as filesystem label and must be at most %d characters for %s", part.Label, limit, disk.Filesystem)
+		}
+	}
+
+	for _, option := range disk.MountOptions {
+		key, _, _ := strings.Cut(option, "=")
+		if !containsString(genericMountOptions, key) && !containsString(filesystemMountOptions[disk.Filesystem], key) {
+			return fmt.Errorf("unknown mount option '%s' for %s", option, disk.Filesystem)
+		}
+	}
+
+	return nil
+}
+
+// validateEphemeral rejects options that need a persistent LUKS header, since
+// ephemeral disks are opened with plain dm-crypt and a key that only exists
+// for the current boot.
+func validateEphemeral(disk DiskConfig) error {
+	if disk.EncryptionKey != "" {
+		return fmt.Errorf("encryption_key cannot be set with format 'ephemeral', a random key is generated on every boot")
+	}
+	if disk.Integrity != "" {
+		return fmt.Errorf("integrity is not supported with format 'ephemeral'")
+	}
+	if disk.Header.Path != "" || disk.Header.Backup != "" {
+		return fmt.Errorf("header is not supported with format 'ephemeral'")
+	}
+	if len(disk.Partitions) > 0 {
+		return fmt.Errorf("partitions are not supported with format 'ephemeral'")
+	}
+	return nil
+}
+
+// validateSwap checks a disk with the swap role. Swap is always encrypted
+// with an ephemeral key, so nothing on it survives a reboot.
+func validateSwap(disk DiskConfig) error {
+	if disk.Format != "ephemeral" {
+		return fmt.Errorf("swap disks only support format 'ephemeral'")
+	}
+	if disk.MountAt != "" || disk.Filesystem != "" || len(disk.MkfsOptions) > 0 || len(disk.MountOptions) > 0 {
+		return fmt.Errorf("mount_at, filesystem, mkfs_options and mount_options do not apply to swap disks")
+	}
+	if disk.SwapPriority != nil && (*disk.SwapPriority < -1 || *disk.SwapPriority > 32767) {
+		return fmt.Errorf("swap_priority must be between -1 and 32767")
+	}
+	return nil
+}
+
+func validateIdentity(identity *IdentityConfig, disk DiskConfig) error {
+	if identity.OnMismatch == "" {
+		identity.OnMismatch = "refuse"
+	}
+	switch identity.OnMismatch {
+	case "refuse", "adopt", "force":
+	default:
+		return fmt.Errorf("on_mismatch must be 'refuse', 'adopt', or 'force'")
+	}
+	if identity.UUID == "" {
+		return nil
+	}
+	if len(disk.Partitions) > 0 {
+		return fmt.Errorf("uuid is not supported on partitioned disks")
+	}
+	if disk.EncryptionKey == "" {
+		return fmt.Errorf("uuid requires encryption_key")
+	}
+	if !isUUID(identity.UUID) {
+		return fmt.Errorf("uuid '%s' is not a valid UUID", identity.UUID)
+	}
+	return nil
+}
+
+// isUUID checks for the 8-4-4-4-12 hex digit form.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, r := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func validateLuks(luks LuksConfig) error {
+	switch luks.PBKDF {
+	case "", "argon2id":
+	case "pbkdf2":
+		if luks.PBKDFMemory != 0 || luks.PBKDFParallel != 0 {
+			return fmt.Errorf("pbkdf_memory and pbkdf_parallel only apply to argon2id")
+		}
+	default:
+		return fmt.Errorf("pbkdf must be 'argon2id' or 'pbkdf2'")
+	}
+	if luks.KeySize < 0 || luks.KeySize%8 != 0 {
+		return fmt.Errorf("key_size must be a positive multiple of 8 bits")
+	}
+	if luks.PBKDFMemory < 0 || luks.PBKDFTime < 0 || luks.PBKDFParallel < 0 {
+		return fmt.Errorf("pbkdf costs must not be negative")
+	}
+	switch luks.SectorSize {
+	case 0, 512, 1024, 2048, 4096:
+	default:
+		return fmt.Errorf("sector_size must be 512, 1024, 2048, or 4096")
+	}
+	if len(luks.Label) > 47 || len(luks.Subsystem) > 47 {
+		return fmt.Errorf("label and subsystem must be at most 47 characters")
+	}
+	if luks.OnMismatch != "warn" && luks.OnMismatch != "error" {
+		return fmt.Errorf("on_mismatch must be 'warn' or 'error'")
+	}
+	return nil
+}
+
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePartitions(parts []PartitionConfig) error {
+	labels := make(map[string]bool)
+	percent := 0
+
+	for i, part := range parts {
+		if part.Label == "" {
+			return fmt.Errorf("partition %d: label is required", i)
+		}
+		if len(part.Label) > 36 {
+			return fmt.Errorf("%s: label must be at most 36 characters", part.Label)
+		}
+		if labels[part.Label] {
+			return fmt.Errorf("%s: duplicate label", part.Label)
+		}
+		labels[part.Label] = true
+
+		if part.MountAt == "" {
+			return fmt.Errorf("%s: mount_at is required", part.Label)
+		}
+
+		size, err := ParsePartitionSize(part.Size)
+		if err != nil {
+			return fmt.Errorf("%s: %w", part.Label, err)
+		}
+		if size.Bytes == 0 && size.Percent == 0 && i != len(parts)-1 {
+			return fmt.Errorf("%s: only the last partition may take the remaining space", part.Label)
+		}
+		percent += size.Percent
+	}
+
+	if percent > 100 {
+		return fmt.Errorf("partition percentages add up to %d%%", percent)
+	}
+
+	return nil
+}
+
+func validateDirectories(dirs []DirectoryConfig) error {
+	seen := make(map[string]bool)
+	for i := range dirs {
+		dir := &dirs[i]
+		if dir.Path == "" || filepath.IsAbs(dir.Path) {
+			return fmt.Errorf("entry %d: path must be relative to the mount point", i)
+		}
+		clean := filepath.Clean(dir.Path)
+		if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("%s: path must stay below the mount point", dir.Path)
+		}
+		if seen[clean] {
+			return fmt.Errorf("%s: duplicate path", dir.Path)
+		}
+		seen[clean] = true
+		dir.Path = clean
+
+		if dir.Mode == "" {
+			dir.Mode = "0700"
+		}
+		if _, err := ParseMode(dir.Mode); err != nil {
+			return fmt.Errorf("%s: %w", dir.Path, err)
+		}
+	}
+	return nil
+}
+
+func validateWait(wait *WaitConfig) error {
+	if wait.Timeout < 0 || wait.Interval < 0 {
+		return fmt.Errorf("timeout and interval must not be negative")
+	}
+	if wait.Timeout == 0 {
+		if wait.Mode != "" || wait.Interval != 0 {
+			return fmt.Errorf("timeout is required")
+		}
+		return nil
+	}
+	if wait.Mode == "" {
+		wait.Mode = "poll"
+	}
+	if wait.Mode != "poll" && wait.Mode != "uevent" {
+		return fmt.Errorf("mode must be 'poll' or 'uevent'")
+	}
+	if wait.Interval == 0 {
+		wait.Interval = time.Second
+	}
+	return nil
+}
+
+func validatePersist(entries []PersistConfig) error {
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Source == "" || filepath.IsAbs(entry.Source) {
+			return fmt.Errorf("entry %d: source must be relative to the mount point", i)
+		}
+		source := filepath.Clean(entry.Source)
+		if source == "." || source == ".." || strings.HasPrefix(source, "../") {
+			return fmt.Errorf("%s: source must stay below the mount point", entry.Source)
+		}
+		entry.Source = source
+
+		if !filepath.IsAbs(entry.Target) {
+			return fmt.Errorf("%s: target must be an absolute path", entry.Target)
+		}
+		entry.Target = filepath.Clean(entry.Target)
+		if entry.Target == "/" {
+			return fmt.Errorf("target must not be the root directory")
+		}
+	}
+	return nil
+}
+
+func validateOverlay(overlay *OverlayConfig, disks map[string]DiskConfig) error {
+	if !filepath.IsAbs(overlay.Target) {
+		return fmt.Errorf("target must be an absolute path")
+	}
+	overlay.Target = filepath.Clean(overlay.Target)
+	if overlay.Target == "/" {
+		return fmt.Errorf("target must not be the root directory")
+	}
+	// overlayfs separates layers with ':' and options with ','
+	if strings.ContainsAny(overlay.Target, ",:") {
+		return fmt.Errorf("target must not contain ',' or ':'")
+	}
+
+	disk, ok := disks[overlay.Disk]
+	if !ok {
+		return fmt.Errorf("disk references non-existent disk '%s'", overlay.Disk)
+	}
+	if disk.MountAt == "" {
+		return fmt.Errorf("disk '%s' has no mount point", overlay.Disk)
+	}
+
+	if overlay.Dir == "" {
+		overlay.Dir = filepath.Join("overlays", strings.ReplaceAll(strings.TrimPrefix(overlay.Target, "/"), "/", "_"))
+	}
+	if filepath.IsAbs(overlay.Dir) {
+		return fmt.Errorf("dir must be relative to the mount point")
+	}
+	overlay.Dir = filepath.Clean(overlay.Dir)
+	if overlay.Dir == "." || overlay.Dir == ".." || strings.HasPrefix(overlay.Dir, "../") {
+		return fmt.Errorf("dir must stay below the mount point")
+	}
+	if strings.ContainsAny(filepath.Join(disk.MountAt, overlay.Dir), ",:") {
+		return fmt.Errorf("dir must not contain ',' or ':'")
+	}
+
+	return nil
+}
+
+// validateOverlayNesting checks that no overlay hides another mount, since
+// overlayfs does not show what is mounted below its lower directory.
+// Overlays are mounted after the disks, in order, and before persisted
+// directories are bound, so a persisted directory may lie inside an overlay
+// target but a disk mount point or a later overlay may not.
+func (c *Config) validateOverlayNesting() error {
+	for i, overlay := range c.Overlays {
+		covers := func(path string) bool {
+			return path == overlay.Target || isBelow(path, overlay.Target)
+		}
+
+		for name, disk := range c.Disks {
+			for _, mp := range diskMountPoints(disk) {
+				if covers(mp) {
+					return fmt.Errorf("overlays[%d]: %s would hide disk %s mounted at %s", i, overlay.Target, name, mp)
+				}
+			}
+			for _, persist := range disk.Persist {
+				if isBelow(overlay.Target, persist.Target) {
+					return fmt.Errorf("overlays[%d]: %s would be hidden by %s, persisted by disk %s", i, overlay.Target, persist.Target, name)
+				}
+			}
+		}
+
+		for j, other := range c.Overlays {
+			if j > i && isBelow(overlay.Target, other.Target) {
+				return fmt.Errorf("overlays[%d]: %s must come after overlays[%d], which would hide it", i, overlay.Target, j)
+			}
+			dir := filepath.Join(c.Disks[other.Disk].MountAt, other.Dir)
+			if covers(dir) || isBelow(overlay.Target, dir) {
+				return fmt.Errorf("overlays[%d]: %s overlaps %s, the directory of overlays[%d]", i, overlay.Target, dir, j)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseMode parses an octal permission string such as "0750".
+func ParseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 07777 {
+		return 0, fmt.Errorf("invalid mode '%s'", s)
+	}
+	perm := os.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		perm |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		perm |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		perm |= os.ModeSticky
+	}
+	return perm, nil
+}
+
+// ParsePartitionSize parses a partition size given as a fixed size ("10G"),
+// a percentage of the disk ("25%"), or "rest"/empty for the remaining space.
+func ParsePartitionSize(s string) (PartitionSize, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "rest" {
+		return PartitionSize{}, nil
+	}
+
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+		if err != nil || percent <= 0 || percent > 100 {
+			return PartitionSize{}, fmt.Errorf("invalid percentage '%s'", s)
+		}
+		return PartitionSize{Percent: percent}, nil
+	}
+
+	bytes, err := ParseSize(s)
+	if err != nil {
+		return PartitionSize{}, err
+	}
+	if bytes == 0 {
+		return PartitionSize{}, fmt.Errorf("size must be greater than zero")
+	}
+	return PartitionSize{Bytes: bytes}, nil
+}
+
+func validateByIDConfig(cfg map[string]interface{}) error {
+	found := false
+	for _, key := range []string{"serial", "wwn", "id", "partuuid"} {
+		value, ok := cfg[key]
+		if !ok {
+			continue
+		}
+		if s, ok := value.(string); !ok || s == "" {
+			return fmt.Errorf("%s must be a non-empty string", key)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("by_id strategy requires at least one of 'serial', 'wwn', 'id', or 'partuuid'")
+	}
+	return nil
+}
+
+func validateSysfsConfig(cfg map[string]interface{}) error {
+	for _, key := range []string{"transport", "model", "vendor"} {
+		if value, ok := cfg[key]; ok {
+			if _, ok := value.(string); !ok {
+				return fmt.Errorf("%s must be a string", key)
+			}
+		}
+	}
+
+	for _, key := range []string{"rotational", "removable", "read_only"} {
+		if value, ok := cfg[key]; ok {
+			if _, ok := value.(bool); !ok {
+				return fmt.Errorf("%s must be true or false", key)
+			}
+		}
+	}
+
+	var sizes [2]int64
+	for i, key := range []string{"min_size", "max_size"} {
+		switch value := cfg[key].(type) {
+		case nil:
+		case int:
+			sizes[i] = int64(value)
+		case string:
+			size, err := ParseSize(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			sizes[i] = size
+		default:
+			return fmt.Errorf("%s must be a size such as '100G'", key)
+		}
+	}
+	if sizes[0] > 0 && sizes[1] > 0 && sizes[0] > sizes[1] {
+		return fmt.Errorf("min_size must not exceed max_size")
+	}
+
+	if value, ok := cfg["select"]; ok {
+		if s, _ := value.(string); s != "largest" && s != "smallest" && s != "first" {
+			return fmt.Errorf("select must be 'largest', 'smallest', or 'first'")
+		}
+	}
+
+	return nil
+}
+
+func validateFileConfig(cfg map[string]interface{}) error {
+	path, _ := cfg["path"].(string)
+	if path == "" || !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("file strategy requires an absolute 'path'")
+	}
+
+	var size int64
+	switch value := cfg["size"].(type) {
+	case int:
+		size = int64(value)
+	case string:
+		var err error
+		if size, err = ParseSize(value); err != nil {
+			return fmt.Errorf("size: %w", err)
+		}
+	default:
+		return fmt.Errorf("file strategy requires a 'size' such as '10G'")
+	}
+	if size <= 0 {
+		return fmt.Errorf("size must be positive")
+	}
+
+	return nil
+}
+
+// ParseSize parses a size such as "512M", "10G" or "1TiB" into bytes. Unit
+// suffixes are binary (powers of 1024); a bare number is taken as bytes.
+func ParseSize(s string) (int64, error) {
+	value := strings.TrimSpace(strings.ToUpper(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		switch value[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			value = value[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+
+	return n * multiplier, nil
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DiskDependencies returns, for every disk, the disks that must be set up
+// before it: those listed in depends_on, and those whose mount point
+// contains one of its mount points, since mounting the parent later would
+// hide the nested mount.
+func (c *Config) DiskDependencies() map[string][]string {
+	mounts := make(map[string][]string)
+	for name, disk := range c.Disks {
+		mounts[name] = diskMountPoints(disk)
+	}
+
+	deps := make(map[string][]string)
+	for name, disk := range c.Disks {
+		seen := make(map[string]bool)
+		add := func(dep string) {
+			if !seen[dep] {
+				seen[dep] = true
+				deps[name] = append(deps[name], dep)
+			}
+		}
+
+		for _, dep := range disk.DependsOn {
+			add(dep)
+		}
+		for other, otherMounts := range mounts {
+			if other == name {
+				continue
+			}
+			for _, mp := range mounts[name] {
+				for _, parent := range otherMounts {
+					if isBelow(mp, parent) {
+						add(other)
+					}
+				}
+			}
+		}
+		sort.Strings(deps[name])
+	}
+
+	return deps
+}
+
+// DiskOrder sorts the disks so that every disk comes after its
+// dependencies. Among disks that are ready at the same time the ssh.store_at
+// disk comes first and the rest are ordered by name, so the order is stable
+// from boot to boot.
+func (c *Config) DiskOrder() ([]string, error) {
+	deps := c.DiskDependencies()
+
+	pending := make(map[string]int)
+	dependents := make(map[string][]string)
+	for name := range c.Disks {
+		pending[name] = len(deps[name])
+		for _, dep := range deps[name] {
+			if _, ok := c.Disks[dep]; !ok {
+				return nil, fmt.Errorf("disks.%s.depends_on references non-existent disk '%s'", name, dep)
+			}
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	var ready []string
+	for name, n := range pending {
+		if n == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	var order []string
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool {
+			if (ready[i] == c.SSH.StoreAt) != (ready[j] == c.SSH.StoreAt) {
+				return ready[i] == c.SSH.StoreAt
+			}
+			return ready[i] < ready[j]
+		})
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+
+		for _, dependent := range dependents[name] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(order) < len(c.Disks) {
+		var cycle []string
+		for name, n := range pending {
+			if n > 0 {
+				cycle = append(cycle, name)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("dependency cycle involving disks %s", strings.Join(cycle, ", "))
+	}
+
+	return order, nil
+}
+
+func diskMountPoints(disk DiskConfig) []string {
+	var mounts []string
+	if disk.MountAt != "" {
+		mounts = append(mounts, filepath.Clean(disk.MountAt))
+	}
+	for _, part := range disk.Partitions {
+		if part.MountAt != "" {
+			mounts = append(mounts, filepath.Clean(part.MountAt))
+		}
+	}
+	return mounts
+}
+
+// isBelow reports whether path lies strictly inside dir.
+func isBelow(path, dir string) bool {
+	if dir == "/" {
+		return path != "/"
+	}
+	return strings.HasPrefix(path, dir+"/")
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiskOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		disks   map[string]DiskConfig
+		storeAt string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "independent disks by name",
+			disks: map[string]DiskConfig{
+				"c": {MountAt: "/c"},
+				"a": {MountAt: "/a"},
+				"b": {MountAt: "/b"},
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "ssh store first among ready disks",
+			disks: map[string]DiskConfig{
+				"a":   {MountAt: "/a"},
+				"ssh": {MountAt: "/ssh"},
+			},
+			storeAt: "ssh",
+			want:    []string{"ssh", "a"},
+		},
+		{
+			name: "ssh store waits for its dependencies",
+			disks: map[string]DiskConfig{
+				"a":   {MountAt: "/a"},
+				"b":   {MountAt: "/b"},
+				"ssh": {MountAt: "/ssh", DependsOn: []string{"b"}},
+			},
+			storeAt: "ssh",
+			want:    []string{"a", "b", "ssh"},
+		},
+		{
+			name: "depends_on",
+			disks: map[string]DiskConfig{
+				"a": {MountAt: "/a", DependsOn: []string{"c"}},
+				"b": {MountAt: "/b"},
+				"c": {MountAt: "/c", DependsOn: []string{"b"}},
+			},
+			want: []string{"b", "c", "a"},
+		},
+		{
+			name: "nested mount points",
+			disks: map[string]DiskConfig{
+				"inner":  {MountAt: "/data/inner"},
+				"outer":  {MountAt: "/data"},
+				"prefix": {MountAt: "/database"},
+			},
+			want: []string{"outer", "inner", "prefix"},
+		},
+		{
+			name: "nested partition mount point",
+			disks: map[string]DiskConfig{
+				"a": {MountAt: "/srv/a"},
+				"z": {Partitions: []PartitionConfig{{Label: "root", MountAt: "/srv"}}},
+			},
+			want: []string{"z", "a"},
+		},
+		{
+			name: "unknown dependency",
+			disks: map[string]DiskConfig{
+				"a": {DependsOn: []string{"missing"}},
+			},
+			wantErr: "non-existent disk 'missing'",
+		},
+		{
+			name: "cycle",
+			disks: map[string]DiskConfig{
+				"a": {DependsOn: []string{"b"}},
+				"b": {DependsOn: []string{"a"}},
+				"c": {},
+			},
+			wantErr: "dependency cycle involving disks a, b",
+		},
+		{
+			name: "cycle through mount points",
+			disks: map[string]DiskConfig{
+				"a": {MountAt: "/a", DependsOn: []string{"b"}},
+				"b": {MountAt: "/a/b"},
+			},
+			wantErr: "dependency cycle involving disks a, b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Disks: tt.disks}
+			cfg.SSH.StoreAt = tt.storeAt
+
+			// Map iteration order varies, so a stable order must come out
+			// the same every time
+			for i := 0; i < 10; i++ {
+				got, err := cfg.DiskOrder()
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("DiskOrder() error = %v, want %q", err, tt.wantErr)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("DiskOrder() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("DiskOrder() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateOverlayNesting(t *testing.T) {
+	disks := map[string]DiskConfig{
+		"persistent": {
+			MountAt: "/persistent",
+			Persist: []PersistConfig{{Source: "persist/docker", Target: "/var/lib/docker"}},
+		},
+		"data": {MountAt: "/srv/data"},
+	}
+
+	tests := []struct {
+		name     string
+		overlays []OverlayConfig
+		wantErr  string
+	}{
+		{
+			name: "persisted directory inside overlay",
+			overlays: []OverlayConfig{
+				{Target: "/etc", Disk: "persistent", Dir: "overlays/etc"},
+				{Target: "/var", Disk: "persistent", Dir: "overlays/var"},
+			},
+		},
+		{
+			name: "nested overlays outer first",
+			overlays: []OverlayConfig{
+				{Target: "/var", Disk: "persistent", Dir: "overlays/var"},
+				{Target: "/var/lib", Disk: "persistent", Dir: "overlays/var_lib"},
+			},
+		},
+		{
+			name: "nested overlays inner first",
+			overlays: []OverlayConfig{
+				{Target: "/var/lib", Disk: "persistent", Dir: "overlays/var_lib"},
+				{Target: "/var", Disk: "persistent", Dir: "overlays/var"},
+			},
+			wantErr: "must come after overlays[1]",
+		},
+		{
+			name:     "disk mounted below target",
+			overlays: []OverlayConfig{{Target: "/srv", Disk: "persistent", Dir: "overlays/srv"}},
+			wantErr:  "would hide disk data",
+		},
+		{
+			name:     "disk mounted at target",
+			overlays: []OverlayConfig{{Target: "/srv/data", Disk: "persistent", Dir: "overlays/data"}},
+			wantErr:  "would hide disk data",
+		},
+		{
+			name:     "target inside persisted directory",
+			overlays: []OverlayConfig{{Target: "/var/lib/docker/volumes", Disk: "persistent", Dir: "overlays/volumes"}},
+			wantErr:  "would be hidden by /var/lib/docker",
+		},
+		{
+			name:     "target contains its directory",
+			overlays: []OverlayConfig{{Target: "/persistent/overlays", Disk: "persistent", Dir: "overlays/x"}},
+			wantErr:  "overlaps /persistent/overlays/x",
+		},
+		{
+			name: "target inside another overlay's directory",
+			overlays: []OverlayConfig{
+				{Target: "/etc", Disk: "persistent", Dir: "overlays/etc"},
+				{Target: "/persistent/overlays/etc/upper", Disk: "data", Dir: "overlays/x"},
+			},
+			wantErr: "overlaps /persistent/overlays/etc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Disks: disks, Overlays: tt.overlays}
+			err := cfg.validateOverlayNesting()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateOverlayNesting() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateOverlayNesting() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
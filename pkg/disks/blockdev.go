@@ -0,0 +1,263 @@
+package disks
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	sysClassBlock = "/sys/class/block"
+	udevDataDir   = "/run/udev/data"
+)
+
+type blockIdentity struct {
+	Name      string
+	Serials   []string
+	WWNs      []string
+	IDs       []string
+	PartUUIDs []string
+}
+
+func listBlockDevices() ([]string, error) {
+	entries, err := os.ReadDir(sysClassBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+func readSysfsAttr(name, attr string) string {
+	data, err := os.ReadFile(filepath.Join(sysClassBlock, name, attr))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func isPartition(name string) bool {
+	return sysfsExists(name, "partition")
+}
+
+func readUdevProperties(name string) map[string]string {
+	props := make(map[string]string)
+
+	devNum := readSysfsAttr(name, "dev")
+	if devNum == "" {
+		return props
+	}
+
+	file, err := os.Open(filepath.Join(udevDataDir, "b"+devNum))
+	if err != nil {
+		return props
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "E:") {
+			continue
+		}
+		key, value, ok := strings.Cut(line[2:], "=")
+		if ok {
+			props[key] = value
+		}
+	}
+
+	return props
+}
+
+// readUdevLinks maps kernel device names to the names of the symlinks in
+// /dev/disk/<dir> that resolve to them.
+func readUdevLinks(dir string) map[string][]string {
+	links := make(map[string][]string)
+
+	entries, err := os.ReadDir(filepath.Join("/dev/disk", dir))
+	if err != nil {
+		return links
+	}
+
+	for _, entry := range entries {
+		target, err := filepath.EvalSymlinks(filepath.Join("/dev/disk", dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(target)
+		links[name] = append(links[name], entry.Name())
+	}
+
+	return links
+}
+
+func collectBlockIdentities() ([]blockIdentity, error) {
+	names, err := listBlockDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	byID := readUdevLinks("by-id")
+	byPartUUID := readUdevLinks("by-partuuid")
+
+	var identities []blockIdentity
+	for _, name := range names {
+		id := blockIdentity{
+			Name:      name,
+			IDs:       byID[name],
+			PartUUIDs: byPartUUID[name],
+		}
+		props := readUdevProperties(name)
+
+		// Partitions inherit the serial and WWN of their parent disk in the
+		// udev database, so only whole disks carry those identifiers.
+		if isPartition(name) {
+			if uuid := props["ID_PART_ENTRY_UUID"]; uuid != "" {
+				id.PartUUIDs = append(id.PartUUIDs, uuid)
+			}
+			identities = append(identities, id)
+			continue
+		}
+
+		for _, serial := range []string{
+			readSysfsAttr(name, "serial"),
+			readSysfsAttr(name, "device/serial"),
+			props["ID_SERIAL_SHORT"],
+			props["ID_SERIAL"],
+		} {
+			if serial != "" {
+				id.Serials = append(id.Serials, serial)
+			}
+		}
+
+		for _, wwn := range []string{
+			readSysfsAttr(name, "wwid"),
+			readSysfsAttr(name, "device/wwid"),
+			props["ID_WWN"],
+			props["ID_WWN_WITH_EXTENSION"],
+		} {
+			if wwn != "" {
+				id.WWNs = append(id.WWNs, normalizeWWN(wwn))
+			}
+		}
+
+		for _, link := range byID[name] {
+			if strings.HasPrefix(link, "wwn-") {
+				id.WWNs = append(id.WWNs, normalizeWWN(strings.TrimPrefix(link, "wwn-")))
+			}
+		}
+
+		identities = append(identities, id)
+	}
+
+	return identities, nil
+}
+
+// normalizeWWN strips the notation prefixes used by sysfs ("naa.", "eui.")
+// and udev ("0x") so that the same identifier compares equal regardless of
+// where it was read from.
+func normalizeWWN(wwn string) string {
+	wwn = strings.ToLower(strings.TrimSpace(wwn))
+	for _, prefix := range []string{"naa.", "eui.", "t10.", "0x"} {
+		wwn = strings.TrimPrefix(wwn, prefix)
+	}
+	return wwn
+}
+
+func sysfsExists(name, attr string) bool {
+	_, err := os.Stat(filepath.Join(sysClassBlock, name, attr))
+	return err == nil
+}
+
+func devNumber(dev uint64) string {
+	major := ((dev >> 8) & 0xfff) | ((dev >> 32) &^ 0xfff)
+	minor := (dev & 0xff) | ((dev >> 12) &^ 0xff)
+	return fmt.Sprintf("%d:%d", major, minor)
+}
+
+func blockNameForDev(dev uint64) string {
+	return blockNameForDevNumber(devNumber(dev))
+}
+
+func blockNameForDevNumber(devNum string) string {
+	target, err := filepath.EvalSymlinks(filepath.Join("/sys/dev/block", devNum))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// parentDisk returns the whole disk a partition belongs to, or the name
+// itself for anything that is not a partition.
+func parentDisk(name string) string {
+	if !isPartition(name) {
+		return name
+	}
+	target, err := filepath.EvalSymlinks(filepath.Join(sysClassBlock, name))
+	if err != nil {
+		return name
+	}
+	return filepath.Base(filepath.Dir(target))
+}
+
+func canonicalDeviceName(device string) string {
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+	return filepath.Base(device)
+}
+
+func partitionNames(name string) []string {
+	entries, err := os.ReadDir(filepath.Join(sysClassBlock, name))
+	if err != nil {
+		return nil
+	}
+
+	var parts []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), name) && isPartition(entry.Name()) {
+			parts = append(parts, entry.Name())
+		}
+	}
+	return parts
+}
+
+// holderNames lists the devices stacked on a device, such as the dm or md
+// devices built from it.
+func holderNames(name string) []string {
+	return sysfsLinks(name, "holders")
+}
+
+// slaveNames lists the devices a dm or md device is built from.
+func slaveNames(name string) []string {
+	return sysfsLinks(name, "slaves")
+}
+
+func sysfsLinks(name, dir string) []string {
+	entries, err := os.ReadDir(filepath.Join(sysClassBlock, name, dir))
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func deviceSize(device string) (int64, error) {
+	name := canonicalDeviceName(device)
+	sectors, err := strconv.ParseInt(readSysfsAttr(name, "size"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read size of %s: %w", device, err)
+	}
+	return sectors * 512, nil
+}
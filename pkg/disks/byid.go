@@ -0,0 +1,95 @@
+package disks
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+type ByIDFinder struct {
+	Serial   string
+	WWN      string
+	ID       string
+	PartUUID string
+}
+
+func NewByIDFinder(serial, wwn, id, partUUID string) *ByIDFinder {
+	if id != "" {
+		id = filepath.Base(id)
+	}
+	return &ByIDFinder{
+		Serial:   serial,
+		WWN:      wwn,
+		ID:       id,
+		PartUUID: partUUID,
+	}
+}
+
+func (f *ByIDFinder) Find() (string, error) {
+	if f.Serial == "" && f.WWN == "" && f.ID == "" && f.PartUUID == "" {
+		return "", fmt.Errorf("no disk identifier configured")
+	}
+
+	identities, err := collectBlockIdentities()
+	if err != nil {
+		return "", fmt.Errorf("failed to read block devices: %w", err)
+	}
+
+	var matches []string
+	for _, id := range identities {
+		if f.matches(id) {
+			matches = append(matches, "/dev/"+id.Name)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no disk found matching %s", f)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%d disks match %s: %s", len(matches), f, strings.Join(matches, ", "))
+	}
+}
+
+func (f *ByIDFinder) matches(id blockIdentity) bool {
+	if f.Serial != "" && !containsFold(id.Serials, f.Serial) {
+		return false
+	}
+	if f.WWN != "" && !containsFold(id.WWNs, normalizeWWN(f.WWN)) {
+		return false
+	}
+	if f.ID != "" && !containsFold(id.IDs, f.ID) {
+		return false
+	}
+	if f.PartUUID != "" && !containsFold(id.PartUUIDs, f.PartUUID) {
+		return false
+	}
+	return true
+}
+
+func (f *ByIDFinder) String() string {
+	var parts []string
+	if f.Serial != "" {
+		parts = append(parts, "serial="+f.Serial)
+	}
+	if f.WWN != "" {
+		parts = append(parts, "wwn="+f.WWN)
+	}
+	if f.ID != "" {
+		parts = append(parts, "id="+f.ID)
+	}
+	if f.PartUUID != "" {
+		parts = append(parts, "partuuid="+f.PartUUID)
+	}
+	return strings.Join(parts, " ")
+}
+
+func containsFold(values []string, want string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, want) {
+			return true
+		}
+	}
+	return false
+}
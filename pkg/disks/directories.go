@@ -0,0 +1,96 @@
+package disks
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"tdx-init/pkg/config"
+)
+
+// defaultDirectories is the layout created when a disk does not configure
+// directories: encrypted disks also get the ssh directory.
+func defaultDirectories(encrypted bool) []config.DirectoryConfig {
+	names := []string{"data", "logs"}
+	if encrypted {
+		names = []string{"ssh", "data", "logs"}
+	}
+
+	dirs := make([]config.DirectoryConfig, 0, len(names))
+	for _, name := range names {
+		dirs = append(dirs, config.DirectoryConfig{Path: name, Mode: "0700"})
+	}
+	return dirs
+}
+
+// ApplyDirectories creates the directories below a mount point and resets
+// their mode, ownership and SELinux context. It runs on every boot, so that
+// changes to the layout also reach disks formatted by an earlier version of
+// the configuration.
+func ApplyDirectories(mountPoint string, dirs []config.DirectoryConfig) error {
+	for _, dir := range dirs {
+		fullPath := filepath.Join(mountPoint, dir.Path)
+
+		mode, err := config.ParseMode(dir.Mode)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(fullPath, 0700); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", fullPath, err)
+		}
+		if err := os.Chmod(fullPath, mode); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %w", fullPath, err)
+		}
+
+		uid, gid, err := lookupOwner(dir.Owner, dir.Group)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fullPath, err)
+		}
+		if uid >= 0 || gid >= 0 {
+			if err := os.Lchown(fullPath, uid, gid); err != nil {
+				return fmt.Errorf("failed to set owner of %s: %w", fullPath, err)
+			}
+		}
+
+		if dir.SELinux != "" {
+			if err := syscall.Setxattr(fullPath, "security.selinux", []byte(dir.SELinux), 0); err != nil {
+				return fmt.Errorf("failed to set SELinux context of %s: %w", fullPath, err)
+			}
+		}
+	}
+	return nil
+}
+
+// lookupOwner resolves user and group names or numeric IDs. Unset values
+// are returned as -1, which leaves them unchanged.
+func lookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+
+	if owner != "" {
+		if id, err := strconv.Atoi(owner); err == nil {
+			uid = id
+		} else {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unknown user %s: %w", owner, err)
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+
+	if group != "" {
+		if id, err := strconv.Atoi(group); err == nil {
+			gid = id
+		} else {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unknown group %s: %w", group, err)
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+
+	return uid, gid, nil
+}
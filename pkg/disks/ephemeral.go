@@ -0,0 +1,67 @@
+package disks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"tdx-init/pkg/config"
+)
+
+const (
+	defaultEphemeralCipher  = "aes-xts-plain64"
+	defaultEphemeralKeySize = 512
+)
+
+// GenerateEphemeralKey returns a random key of keySize bits. The key only
+// lives in memory and in the kernel's dm-crypt mapping, so anything written
+// with it is unreadable once the machine is powered off.
+func GenerateEphemeralKey(keySize int) ([]byte, error) {
+	if keySize <= 0 {
+		keySize = defaultEphemeralKeySize
+	}
+	key := make([]byte, keySize/8)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	return key, nil
+}
+
+// OpenPlainCrypt maps a device with plain dm-crypt, which keeps no header
+// on the device. The key is passed to cryptsetup on stdin and used as is,
+// without hashing.
+func OpenPlainCrypt(devicePath, mapperName string, key []byte, params config.LuksConfig) error {
+	cipher := params.Cipher
+	if cipher == "" {
+		cipher = defaultEphemeralCipher
+	}
+
+	args := []string{
+		"open", "--type", "plain",
+		"--cipher", cipher,
+		"--key-size", fmt.Sprint(len(key) * 8),
+		"--key-file", "-",
+		"--keyfile-size", fmt.Sprint(len(key)),
+	}
+	if params.SectorSize > 0 {
+		args = append(args, "--sector-size", fmt.Sprint(params.SectorSize))
+	}
+	args = append(args, devicePath, mapperName)
+
+	log.Printf("Opening %s with plain dm-crypt and an ephemeral key", devicePath)
+	cmd := exec.Command("cryptsetup", args...)
+	cmd.Stdin = bytes.NewReader(key)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to open plain dm-crypt device: %w (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// wipeKey overwrites key material that is no longer needed.
+func wipeKey(key []byte) {
+	for i := range key {
+		key[i] = 0
+	}
+}
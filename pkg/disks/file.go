@@ -0,0 +1,83 @@
+package disks
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// FileFinder keeps the disk in a sparse image file attached through a loop
+// device, for machines without a spare block device. The file is created on
+// first use and grown when the configured size increases; it is never
+// shrunk. Attached is set when Find attached the file itself rather than
+// finding it attached already, so that the caller knows to detach it again
+// if the device is not used after all.
+type FileFinder struct {
+	Path     string
+	Size     int64
+	Attached bool
+}
+
+func NewFileFinder(path string, size int64) *FileFinder {
+	return &FileFinder{
+		Path: path,
+		Size: size,
+	}
+}
+
+func (f *FileFinder) Find() (string, error) {
+	f.Attached = false
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %w", f.Path, err)
+	}
+
+	file, err := os.OpenFile(f.Path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to open image file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to stat image file: %w", err)
+	}
+
+	grown := false
+	switch {
+	case info.Size() < f.Size:
+		if info.Size() == 0 {
+			log.Printf("Creating image file %s of %d bytes", f.Path, f.Size)
+		} else {
+			log.Printf("Growing image file %s from %d to %d bytes", f.Path, info.Size(), f.Size)
+		}
+		if err := file.Truncate(f.Size); err != nil {
+			file.Close()
+			return "", fmt.Errorf("failed to resize image file: %w", err)
+		}
+		grown = info.Size() > 0
+	case info.Size() > f.Size:
+		log.Printf("Warning: Image file %s is larger than the configured size, not shrinking", f.Path)
+	}
+	file.Close()
+
+	device, err := FindLoopDevice(f.Path)
+	if err != nil {
+		return "", err
+	}
+	if device != "" {
+		if grown {
+			if err := RefreshLoopCapacity(device); err != nil {
+				return "", err
+			}
+		}
+		return device, nil
+	}
+
+	device, err = AttachLoopDevice(f.Path)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Attached image file %s to %s", f.Path, device)
+	f.Attached = true
+	return device, nil
+}
@@ -5,35 +5,168 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
+	"syscall"
 )
 
-func CreateFilesystem(device string) error {
-	log.Printf("Creating ext4 filesystem on %s", device)
-	if err := exec.Command("mkfs.ext4", device).Run(); err != nil {
-		return fmt.Errorf("failed to create filesystem: %w", err)
+const msLazytime = 1 << 25
+
+type mountFlag struct {
+	flag  uintptr
+	clear bool
+}
+
+var mountFlags = map[string]mountFlag{
+	"ro":          {syscall.MS_RDONLY, false},
+	"rw":          {syscall.MS_RDONLY, true},
+	"nosuid":      {syscall.MS_NOSUID, false},
+	"suid":        {syscall.MS_NOSUID, true},
+	"nodev":       {syscall.MS_NODEV, false},
+	"dev":         {syscall.MS_NODEV, true},
+	"noexec":      {syscall.MS_NOEXEC, false},
+	"exec":        {syscall.MS_NOEXEC, true},
+	"sync":        {syscall.MS_SYNCHRONOUS, false},
+	"async":       {syscall.MS_SYNCHRONOUS, true},
+	"dirsync":     {syscall.MS_DIRSYNC, false},
+	"noatime":     {syscall.MS_NOATIME, false},
+	"atime":       {syscall.MS_NOATIME, true},
+	"nodiratime":  {syscall.MS_NODIRATIME, false},
+	"diratime":    {syscall.MS_NODIRATIME, true},
+	"relatime":    {syscall.MS_RELATIME, false},
+	"norelatime":  {syscall.MS_RELATIME, true},
+	"strictatime": {syscall.MS_STRICTATIME, false},
+	"lazytime":    {msLazytime, false},
+	"nolazytime":  {msLazytime, true},
+}
+
+func CreateFilesystem(device, fsType, label string, mkfsOptions []string) error {
+	if fsType == "" {
+		fsType = "ext4"
+	}
+
+	var args []string
+	switch fsType {
+	case "xfs", "btrfs":
+		// Both refuse to overwrite an existing signature without -f; the
+		// decision to format has already been made by the format strategy.
+		args = append(args, "-f")
+	}
+	if label != "" {
+		args = append(args, "-L", label)
+	}
+	args = append(args, mkfsOptions...)
+	args = append(args, device)
+
+	log.Printf("Creating %s filesystem on %s", fsType, device)
+	if output, err := exec.Command("mkfs."+fsType, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create filesystem: %w (output: %s)", err, strings.TrimSpace(string(output)))
 	}
 	return nil
 }
 
-func MountDevice(device, mountPoint string) error {
+// DetectFilesystem returns the type of the filesystem signature on a device,
+// or an empty string if it has none.
+func DetectFilesystem(device string) string {
+	return probeTag(device, "TYPE")
+}
+
+// FilesystemUUID returns the UUID of the filesystem on a device, or an empty
+// string if it has none.
+func FilesystemUUID(device string) string {
+	return probeTag(device, "UUID")
+}
+
+func probeTag(device, tag string) string {
+	output, err := exec.Command("blkid", "-p", "-s", tag, "-o", "value", device).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// ParseMountOptions splits mount options into the flags understood by
+// mount(2) and the filesystem-specific data string passed through to the
+// kernel, the same way mount(8) does.
+func ParseMountOptions(options []string) (uintptr, string) {
+	var flags uintptr
+	var data []string
+
+	for _, option := range options {
+		if mf, ok := mountFlags[option]; ok {
+			if mf.clear {
+				flags &^= mf.flag
+			} else {
+				flags |= mf.flag
+			}
+			continue
+		}
+		data = append(data, option)
+	}
+
+	return flags, strings.Join(data, ",")
+}
+
+func MountDevice(device, mountPoint, fsType string, mountOptions []string) error {
 	if IsMounted(mountPoint) {
 		log.Printf("Device already mounted at %s", mountPoint)
 		return nil
 	}
 
+	if fsType == "" {
+		fsType = "ext4"
+	}
+
 	if err := os.MkdirAll(mountPoint, 0755); err != nil {
 		return fmt.Errorf("failed to create mount point: %w", err)
 	}
 
-	if err := exec.Command("mount", device, mountPoint).Run(); err != nil {
+	flags, data := ParseMountOptions(mountOptions)
+	if err := syscall.Mount(device, mountPoint, fsType, flags, data); err != nil {
 		return fmt.Errorf("failed to mount device: %w", err)
 	}
 
 	return nil
 }
 
+// BindMount makes source visible at target. The target directory is created
+// if needed.
+func BindMount(source, target string) error {
+	if IsMounted(target) {
+		log.Printf("Bind mount already present at %s", target)
+		return nil
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("failed to create bind mount target: %w", err)
+	}
+
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("failed to bind mount %s on %s: %w", source, target, err)
+	}
+
+	return nil
+}
+
+// MountOverlay mounts overlayfs at target. The lower directory may be the
+// target itself, in which case the overlay shadows the original directory.
+func MountOverlay(lower, upper, work, target string) error {
+	if IsMounted(target) {
+		log.Printf("Overlay already mounted at %s", target)
+		return nil
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("failed to create overlay target: %w", err)
+	}
+
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lower, upper, work)
+	if err := syscall.Mount("overlay", target, "overlay", 0, data); err != nil {
+		return fmt.Errorf("failed to mount overlay on %s: %w", target, err)
+	}
+
+	return nil
+}
+
 func UnmountDevice(mountPoint string) error {
 	if !IsMounted(mountPoint) {
 		return nil
@@ -48,13 +181,3 @@ func IsMounted(mountPoint string) bool {
 	}
 	return strings.Contains(string(data), " "+mountPoint+" ")
 }
-
-func CreateMountDirs(mountPoint string, dirs []string) error {
-	for _, dir := range dirs {
-		fullPath := filepath.Join(mountPoint, dir)
-		if err := os.MkdirAll(fullPath, 0700); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", fullPath, err)
-		}
-	}
-	return nil
-}
\ No newline at end of file
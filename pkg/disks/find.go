@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -15,6 +14,34 @@ type DiskFinder interface {
 	Find() (string, error)
 }
 
+// CandidateFinder is implemented by finders that can rank every device they
+// match, best first. The manager uses it to fall back to the next candidate
+// when the best match is already claimed by another disk. Candidates are not
+// filtered for use by the running system, which the manager checks itself so
+// that devices held by the disk's own mapper are allowed; Find returns the
+// best candidate that is not in use.
+type CandidateFinder interface {
+	DiskFinder
+	Candidates() ([]string, error)
+}
+
+// firstUnused returns the first of the candidates that is not mounted, used
+// as swap or held by another device.
+func firstUnused(candidates []string) (string, error) {
+	detector, err := NewInUseDetector()
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect devices in use: %w", err)
+	}
+
+	var lastErr error
+	for _, device := range candidates {
+		if lastErr = detector.Check(device); lastErr == nil {
+			return device, nil
+		}
+	}
+	return "", lastErr
+}
+
 func CreateDiskFinder(cfg config.DiskConfig) (DiskFinder, error) {
 	switch cfg.Strategy {
 	case "largest":
@@ -27,11 +54,63 @@ func CreateDiskFinder(cfg config.DiskConfig) (DiskFinder, error) {
 		}
 		return NewPathGlobFinder(pattern), nil
 
+	case "by_id":
+		serial, _ := cfg.StrategyConfig["serial"].(string)
+		wwn, _ := cfg.StrategyConfig["wwn"].(string)
+		id, _ := cfg.StrategyConfig["id"].(string)
+		partUUID, _ := cfg.StrategyConfig["partuuid"].(string)
+		return NewByIDFinder(serial, wwn, id, partUUID), nil
+
+	case "sysfs":
+		finder := NewSysfsFinder()
+		finder.Transport, _ = cfg.StrategyConfig["transport"].(string)
+		finder.Model, _ = cfg.StrategyConfig["model"].(string)
+		finder.Vendor, _ = cfg.StrategyConfig["vendor"].(string)
+		finder.ReadOnly, _ = cfg.StrategyConfig["read_only"].(bool)
+		if v, ok := cfg.StrategyConfig["rotational"].(bool); ok {
+			finder.Rotational = &v
+		}
+		if v, ok := cfg.StrategyConfig["removable"].(bool); ok {
+			finder.Removable = &v
+		}
+		if s, ok := cfg.StrategyConfig["select"].(string); ok {
+			finder.Select = s
+		}
+		var err error
+		if finder.MinSize, err = sizeOption(cfg.StrategyConfig, "min_size"); err != nil {
+			return nil, err
+		}
+		if finder.MaxSize, err = sizeOption(cfg.StrategyConfig, "max_size"); err != nil {
+			return nil, err
+		}
+		return finder, nil
+
+	case "file":
+		path, _ := cfg.StrategyConfig["path"].(string)
+		size, err := sizeOption(cfg.StrategyConfig, "size")
+		if err != nil {
+			return nil, err
+		}
+		return NewFileFinder(path, size), nil
+
 	default:
 		return nil, fmt.Errorf("unknown disk strategy: %s", cfg.Strategy)
 	}
 }
 
+func sizeOption(options map[string]interface{}, key string) (int64, error) {
+	switch v := options[key].(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return int64(v), nil
+	case string:
+		return config.ParseSize(v)
+	default:
+		return 0, fmt.Errorf("%s must be a size", key)
+	}
+}
+
 func FindFirstDiskByPathGlob(path string) (string, error) {
 	disks, err := filepath.Glob(fmt.Sprintf("/dev/disk/by-path/%s", path))
 	if err != nil {
@@ -51,12 +130,17 @@ func FindDiskByGlob(pattern string) (string, error) {
 		return "", fmt.Errorf("invalid glob pattern: %w", err)
 	}
 
+	detector, err := NewInUseDetector()
+	if err != nil {
+		return "", err
+	}
+
 	for _, path := range matches {
 		if !strings.HasPrefix(path, "/dev/") {
 			continue
 		}
 
-		if isBootDevice(path) {
+		if detector.Check(path) != nil {
 			continue
 		}
 
@@ -73,17 +157,6 @@ func FindDiskByGlob(pattern string) (string, error) {
 	return "", fmt.Errorf("no disk found matching pattern %s", pattern)
 }
 
-func isBootDevice(device string) bool {
-	cmd := exec.Command("findmnt", "-n", "-o", "SOURCE", "/")
-	output, err := cmd.Output()
-	if err != nil {
-		return false
-	}
-
-	bootDevice := strings.TrimSpace(string(output))
-	return strings.HasPrefix(bootDevice, device)
-}
-
 func FindLargestDisk() (string, error) {
 	file, err := os.Open("/proc/partitions")
 	if err != nil {
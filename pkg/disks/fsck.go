@@ -0,0 +1,115 @@
+package disks
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// Outcomes of a filesystem check.
+const (
+	FsckClean    = "clean"
+	FsckRepaired = "repaired"
+	FsckErrors   = "errors"
+	FsckFailed   = "failed"
+
+	// FsckUnavailable means the checker is not installed, which says
+	// nothing about the filesystem
+	FsckUnavailable = "unavailable"
+)
+
+var fsckTools = map[string]string{
+	"ext4":  "e2fsck",
+	"xfs":   "xfs_repair",
+	"btrfs": "btrfs",
+}
+
+// e2fsck exit code bits, see e2fsck(8).
+const (
+	e2fsckCorrected   = 1
+	e2fsckReboot      = 2
+	e2fsckUncorrected = 4
+)
+
+// CheckFilesystem runs the checker for the filesystem type on an unmounted
+// device and classifies the result. With repair the checker may fix what it
+// finds; otherwise the device is opened read-only. The returned output is
+// what the checker printed, for the logs.
+func CheckFilesystem(device, fsType string, repair bool) (string, string, error) {
+	if tool, ok := fsckTools[fsType]; ok {
+		if _, err := exec.LookPath(tool); err != nil {
+			return FsckUnavailable, "", fmt.Errorf("%s checker %s is not installed", fsType, tool)
+		}
+	}
+
+	var cmd *exec.Cmd
+	switch fsType {
+	case "ext4":
+		// Replay the journal first, as mounting would, so that a read-only
+		// check after an unclean shutdown does not report the unreplayed
+		// transactions as corruption
+		exec.Command("e2fsck", "-p", "-E", "journal_only", device).Run()
+		if repair {
+			cmd = exec.Command("e2fsck", "-p", device)
+		} else {
+			cmd = exec.Command("e2fsck", "-n", device)
+		}
+	case "xfs":
+		if repair {
+			cmd = exec.Command("xfs_repair", device)
+		} else {
+			cmd = exec.Command("xfs_repair", "-n", device)
+		}
+	case "btrfs":
+		// btrfs check --repair is documented as a last resort, so btrfs is
+		// only ever checked; it repairs from redundant copies on its own
+		if repair {
+			log.Printf("Warning: Not repairing btrfs filesystem on %s, checking only", device)
+		}
+		cmd = exec.Command("btrfs", "check", "--readonly", device)
+	default:
+		return "", "", fmt.Errorf("no filesystem checker for %s", fsType)
+	}
+
+	output, err := cmd.CombinedOutput()
+	out := strings.TrimSpace(string(output))
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return FsckFailed, out, fmt.Errorf("failed to run %s: %w", cmd.Path, err)
+	}
+	code := cmd.ProcessState.ExitCode()
+
+	switch fsType {
+	case "ext4":
+		switch {
+		case code == 0:
+			return FsckClean, out, nil
+		case code&^(e2fsckCorrected|e2fsckReboot) == 0:
+			return FsckRepaired, out, nil
+		case code&^(e2fsckCorrected|e2fsckReboot|e2fsckUncorrected) == 0:
+			return FsckErrors, out, nil
+		}
+	case "xfs":
+		switch code {
+		case 0:
+			// xfs_repair does not tell whether it changed anything
+			return FsckClean, out, nil
+		case 1:
+			return FsckErrors, out, nil
+		case 2:
+			// A dirty log is replayed by the kernel when mounting
+			log.Printf("XFS log on %s needs replay, leaving it to the mount", device)
+			return FsckClean, out, nil
+		}
+	case "btrfs":
+		if code == 0 {
+			return FsckClean, out, nil
+		}
+		return FsckErrors, out, nil
+	}
+
+	return FsckFailed, out, fmt.Errorf("%s exited with status %d", cmd.Path, code)
+}
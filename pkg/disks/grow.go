@@ -0,0 +1,140 @@
+package disks
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// growThreshold is the least amount of unused space that triggers a grow.
+// Filesystems round their size down to whole blocks or allocation groups,
+// so a small difference is normal.
+const growThreshold = 64 << 20
+
+// LuksPayloadOffset returns the offset of the encrypted data on the device,
+// which is zero with a detached header.
+func LuksPayloadOffset(meta *LuksMetadata) (int64, error) {
+	segment, ok := meta.Segments["0"]
+	if !ok {
+		return 0, fmt.Errorf("LUKS header has no data segment")
+	}
+	offset, err := strconv.ParseInt(segment.Offset, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid segment offset %q: %w", segment.Offset, err)
+	}
+	return offset, nil
+}
+
+// ResizeLuks extends an active LUKS mapping to the end of its device.
+func ResizeLuks(mapperName, passphrase, header string) error {
+	args := []string{"resize", "--key-file", "-"}
+	if header != "" {
+		args = append(args, "--header", header)
+	}
+	args = append(args, mapperName)
+
+	cmd := exec.Command("cryptsetup", args...)
+	cmd.Stdin = strings.NewReader(passphrase)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to resize LUKS mapping: %w (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// FilesystemSize returns the size of a mounted filesystem as recorded in its
+// superblock.
+func FilesystemSize(device, mountPoint, fsType string) (int64, error) {
+	switch fsType {
+	case "ext4":
+		output, err := exec.Command("dumpe2fs", "-h", device).Output()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read ext4 superblock: %w", err)
+		}
+		fields := colonFields(output)
+		blocks, err1 := strconv.ParseInt(fields["Block count"], 10, 64)
+		blockSize, err2 := strconv.ParseInt(fields["Block size"], 10, 64)
+		if err1 != nil || err2 != nil {
+			return 0, fmt.Errorf("failed to parse ext4 superblock of %s", device)
+		}
+		return blocks * blockSize, nil
+
+	case "xfs":
+		output, err := exec.Command("xfs_info", mountPoint).Output()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read xfs geometry: %w", err)
+		}
+		// The data section line reads "data = bsize=4096 blocks=262144, ..."
+		for _, line := range strings.Split(string(output), "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "data") {
+				continue
+			}
+			var bsize, blocks int64
+			for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == ',' }) {
+				key, value, _ := strings.Cut(field, "=")
+				switch key {
+				case "bsize":
+					bsize, _ = strconv.ParseInt(value, 10, 64)
+				case "blocks":
+					blocks, _ = strconv.ParseInt(value, 10, 64)
+				}
+			}
+			if bsize > 0 && blocks > 0 {
+				return bsize * blocks, nil
+			}
+		}
+		return 0, fmt.Errorf("failed to parse xfs geometry of %s", mountPoint)
+
+	case "btrfs":
+		output, err := exec.Command("btrfs", "filesystem", "show", "--raw", mountPoint).Output()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read btrfs devices: %w", err)
+		}
+		// Device lines read "devid 1 size 10737418240 used ... path /dev/..."
+		for _, line := range strings.Split(string(output), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[0] == "devid" && fields[2] == "size" {
+				return strconv.ParseInt(fields[3], 10, 64)
+			}
+		}
+		return 0, fmt.Errorf("failed to parse btrfs devices of %s", mountPoint)
+
+	default:
+		return 0, fmt.Errorf("cannot determine size of %s filesystem", fsType)
+	}
+}
+
+// GrowFilesystem grows a mounted filesystem to fill its device.
+func GrowFilesystem(device, mountPoint, fsType string) error {
+	var cmd *exec.Cmd
+	switch fsType {
+	case "ext4":
+		cmd = exec.Command("resize2fs", device)
+	case "xfs":
+		cmd = exec.Command("xfs_growfs", mountPoint)
+	case "btrfs":
+		cmd = exec.Command("btrfs", "filesystem", "resize", "max", mountPoint)
+	default:
+		return fmt.Errorf("cannot grow %s filesystem", fsType)
+	}
+
+	log.Printf("Growing %s filesystem on %s", fsType, device)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to grow filesystem: %w (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func colonFields(output []byte) map[string]string {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), ":"); ok {
+			fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return fields
+}
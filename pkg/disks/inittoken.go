@@ -0,0 +1,246 @@
+package disks
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// InitTokenVersion is the schema version written to new init tokens.
+// Version 1 tokens carried no version field; they named the disk and its
+// device and, once phases were tracked, the initialization phase.
+const InitTokenVersion = 2
+
+// InitToken is the content of the init token of an encrypted disk: its
+// initialization phase and where, how and with what the disk was set up.
+// DiskName and DiskUUID, the UUID of the LUKS container, identify the disk
+// entry the container belongs to.
+//
+// The fields are stored as flat strings in the token's user data, which is
+// what older versions of tdx-init expect to parse.
+type InitToken struct {
+	Version        int
+	Phase          string
+	DiskName       string
+	DiskUUID       string
+	Device         string
+	ToolVersion    string
+	ConfigHash     string
+	CreatedAt      time.Time
+	LastOpenedAt   time.Time
+	KeyName        string
+	KeyStrategy    string
+	Filesystem     string
+	FilesystemUUID string
+	Luks           LuksParams
+
+	// Set when auto_grow last grew the filesystem
+	FilesystemSize int64
+	GrownFrom      int64
+	GrownAt        time.Time
+
+	// extra keeps fields this version does not know, so that rewriting the
+	// token does not drop them
+	extra map[string]string
+}
+
+// LuksParams are the parameters a LUKS2 container was formatted with, as
+// read back from its header.
+type LuksParams struct {
+	Cipher     string
+	KeySize    int
+	Hash       string
+	PBKDF      string
+	SectorSize int
+	Integrity  string
+}
+
+// InitState is what the init token of a LUKS device says about it. Token is
+// nil when the device has no readable init token.
+type InitState struct {
+	Initialized bool
+	Phase       string
+	Token       *InitToken
+}
+
+func IsInitialized(devicePath string) InitState {
+	token, err := ReadInitToken(devicePath)
+	if err != nil {
+		return InitState{Phase: PhaseFormatted}
+	}
+	return InitState{
+		Initialized: token.Phase == PhaseComplete,
+		Phase:       token.Phase,
+		Token:       token,
+	}
+}
+
+// ReadInitToken reads the init token of a LUKS device, migrating tokens
+// written by older versions to the current schema.
+func ReadInitToken(devicePath string) (*InitToken, error) {
+	var data map[string]string
+	if err := NewTokenStore(devicePath).Get(InitTokenType, &data); err != nil {
+		return nil, err
+	}
+	return parseInitToken(data)
+}
+
+// StoreInitToken writes the init token of a freshly formatted device. It
+// fails if the device already has one.
+func StoreInitToken(devicePath string, init *InitToken) error {
+	store := NewTokenStore(devicePath)
+	var existing map[string]string
+	err := store.Get(InitTokenType, &existing)
+	if err == nil {
+		return fmt.Errorf("%s already has an init token", devicePath)
+	}
+	if !errors.Is(err, ErrTokenNotFound) {
+		return err
+	}
+	return store.Put(InitTokenType, init.userData())
+}
+
+// UpdateInitToken replaces the init token of a device.
+func UpdateInitToken(devicePath string, init *InitToken) error {
+	if init.Version > InitTokenVersion {
+		return fmt.Errorf("init token version %d is newer than this version of tdx-init supports", init.Version)
+	}
+	return NewTokenStore(devicePath).Put(InitTokenType, init.userData())
+}
+
+var initTokenFields = map[string]bool{
+	"version": true, "phase": true, "initialized": true,
+	"disk_name": true, "disk_uuid": true, "device": true,
+	"tool_version": true, "config_hash": true,
+	"created_at": true, "last_opened_at": true,
+	"key_name": true, "key_strategy": true,
+	"filesystem": true, "filesystem_uuid": true,
+	"luks_cipher": true, "luks_key_size": true, "luks_hash": true,
+	"luks_pbkdf": true, "luks_sector_size": true, "luks_integrity": true,
+	"filesystem_size": true, "grown_from": true, "grown_at": true,
+}
+
+func parseInitToken(data map[string]string) (*InitToken, error) {
+	version := 1
+	if v, ok := data["version"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid init token version %q", v)
+		}
+		version = n
+	}
+
+	init := &InitToken{
+		Version:        version,
+		Phase:          data["phase"],
+		DiskName:       data["disk_name"],
+		DiskUUID:       data["disk_uuid"],
+		Device:         data["device"],
+		ToolVersion:    data["tool_version"],
+		ConfigHash:     data["config_hash"],
+		CreatedAt:      parseTime(data["created_at"]),
+		LastOpenedAt:   parseTime(data["last_opened_at"]),
+		KeyName:        data["key_name"],
+		KeyStrategy:    data["key_strategy"],
+		Filesystem:     data["filesystem"],
+		FilesystemUUID: data["filesystem_uuid"],
+		Luks: LuksParams{
+			Cipher:     data["luks_cipher"],
+			KeySize:    int(parseInt(data["luks_key_size"])),
+			Hash:       data["luks_hash"],
+			PBKDF:      data["luks_pbkdf"],
+			SectorSize: int(parseInt(data["luks_sector_size"])),
+			Integrity:  data["luks_integrity"],
+		},
+		FilesystemSize: parseInt(data["filesystem_size"]),
+		GrownFrom:      parseInt(data["grown_from"]),
+		GrownAt:        parseTime(data["grown_at"]),
+	}
+	for k, v := range data {
+		if !initTokenFields[k] {
+			if init.extra == nil {
+				init.extra = make(map[string]string)
+			}
+			init.extra[k] = v
+		}
+	}
+
+	if version == 1 {
+		// Tokens of disks set up before phases were tracked only have
+		// the initialized flag, which was written along with the token
+		if init.Phase == "" {
+			init.Phase = PhaseTokenWritten
+			if data["initialized"] == "true" {
+				init.Phase = PhaseComplete
+			}
+		}
+		init.Version = InitTokenVersion
+	}
+
+	return init, nil
+}
+
+func (t *InitToken) userData() map[string]string {
+	data := make(map[string]string)
+	for k, v := range t.extra {
+		data[k] = v
+	}
+
+	set := func(key, value string) {
+		if value != "" {
+			data[key] = value
+		}
+	}
+	setInt := func(key string, value int64) {
+		if value != 0 {
+			data[key] = strconv.FormatInt(value, 10)
+		}
+	}
+	setTime := func(key string, value time.Time) {
+		if !value.IsZero() {
+			data[key] = value.UTC().Format(time.RFC3339)
+		}
+	}
+
+	data["version"] = strconv.Itoa(t.Version)
+	set("phase", t.Phase)
+	if t.Phase == PhaseComplete {
+		// Versions that predate phases only look at this flag
+		data["initialized"] = "true"
+	}
+	set("disk_name", t.DiskName)
+	set("disk_uuid", t.DiskUUID)
+	set("device", t.Device)
+	set("tool_version", t.ToolVersion)
+	set("config_hash", t.ConfigHash)
+	setTime("created_at", t.CreatedAt)
+	setTime("last_opened_at", t.LastOpenedAt)
+	set("key_name", t.KeyName)
+	set("key_strategy", t.KeyStrategy)
+	set("filesystem", t.Filesystem)
+	set("filesystem_uuid", t.FilesystemUUID)
+	set("luks_cipher", t.Luks.Cipher)
+	setInt("luks_key_size", int64(t.Luks.KeySize))
+	set("luks_hash", t.Luks.Hash)
+	set("luks_pbkdf", t.Luks.PBKDF)
+	setInt("luks_sector_size", int64(t.Luks.SectorSize))
+	set("luks_integrity", t.Luks.Integrity)
+	setInt("filesystem_size", t.FilesystemSize)
+	setInt("grown_from", t.GrownFrom)
+	setTime("grown_at", t.GrownAt)
+
+	return data
+}
+
+// Unparseable values are treated as unset, since the token is informational
+// apart from its phase.
+func parseTime(value string) time.Time {
+	t, _ := time.Parse(time.RFC3339, value)
+	return t
+}
+
+func parseInt(value string) int64 {
+	n, _ := strconv.ParseInt(value, 10, 64)
+	return n
+}
@@ -0,0 +1,152 @@
+package disks
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseInitTokenMigration(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      map[string]string
+		wantPhase string
+		wantErr   bool
+	}{
+		{
+			name:      "v1 initialized",
+			data:      map[string]string{"initialized": "true", "disk_name": "data", "device": "/dev/sdb"},
+			wantPhase: PhaseComplete,
+		},
+		{
+			name:      "v1 not initialized",
+			data:      map[string]string{"disk_name": "data", "device": "/dev/sdb"},
+			wantPhase: PhaseTokenWritten,
+		},
+		{
+			name:      "v1 initialized false",
+			data:      map[string]string{"initialized": "false"},
+			wantPhase: PhaseTokenWritten,
+		},
+		{
+			name:      "v1 with phase",
+			data:      map[string]string{"phase": PhaseFilesystemCreated},
+			wantPhase: PhaseFilesystemCreated,
+		},
+		{
+			name:      "v2 phase wins over initialized",
+			data:      map[string]string{"version": "2", "phase": PhaseLayoutApplied, "initialized": "true"},
+			wantPhase: PhaseLayoutApplied,
+		},
+		{
+			name:    "invalid version",
+			data:    map[string]string{"version": "two"},
+			wantErr: true,
+		},
+		{
+			name:    "zero version",
+			data:    map[string]string{"version": "0"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := parseInitToken(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseInitToken() = %+v, want error", token)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseInitToken() error = %v", err)
+			}
+			if token.Phase != tt.wantPhase {
+				t.Errorf("Phase = %q, want %q", token.Phase, tt.wantPhase)
+			}
+			if token.Version != InitTokenVersion {
+				t.Errorf("Version = %d, want %d", token.Version, InitTokenVersion)
+			}
+			if token.DiskName != tt.data["disk_name"] || token.Device != tt.data["device"] {
+				t.Errorf("DiskName, Device = %q, %q, want %q, %q", token.DiskName, token.Device, tt.data["disk_name"], tt.data["device"])
+			}
+
+			// A migrated token is written back as the current version
+			rewritten, err := parseInitToken(token.userData())
+			if err != nil {
+				t.Fatalf("parsing migrated token: %v", err)
+			}
+			if !reflect.DeepEqual(rewritten, token) {
+				t.Errorf("migrated token = %+v after rewriting, want %+v", rewritten, token)
+			}
+		})
+	}
+}
+
+func TestInitTokenRoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		token InitToken
+	}{
+		{
+			name:  "minimal",
+			token: InitToken{Version: InitTokenVersion, Phase: PhaseTokenWritten},
+		},
+		{
+			name: "full",
+			token: InitToken{
+				Version:        InitTokenVersion,
+				Phase:          PhaseComplete,
+				DiskName:       "data",
+				DiskUUID:       "0b6f3c4e-8d1a-4b2e-9f3c-1a2b3c4d5e6f",
+				Device:         "/dev/nvme1n1",
+				ToolVersion:    "v1.2.3",
+				ConfigHash:     "sha256:abc",
+				CreatedAt:      created,
+				LastOpenedAt:   created.Add(time.Hour),
+				KeyName:        "key",
+				KeyStrategy:    "random",
+				Filesystem:     "ext4",
+				FilesystemUUID: "5e6f",
+				Luks: LuksParams{
+					Cipher:     "aes-xts-plain64",
+					KeySize:    512,
+					Hash:       "sha256",
+					PBKDF:      "argon2id",
+					SectorSize: 4096,
+					Integrity:  "hmac(sha256)",
+				},
+				FilesystemSize: 1 << 30,
+				GrownFrom:      1 << 29,
+				GrownAt:        created.Add(2 * time.Hour),
+			},
+		},
+		{
+			name: "unknown fields kept",
+			token: InitToken{
+				Version: InitTokenVersion,
+				Phase:   PhaseFilesystemCreated,
+				extra:   map[string]string{"future_field": "value"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.token.userData()
+			if got, want := data["initialized"] == "true", tt.token.Phase == PhaseComplete; got != want {
+				t.Errorf("initialized flag = %v, want %v", got, want)
+			}
+
+			got, err := parseInitToken(data)
+			if err != nil {
+				t.Fatalf("parseInitToken() error = %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.token) {
+				t.Errorf("round trip = %+v, want %+v", *got, tt.token)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package disks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	IntegrityWiping   = "wiping"
+	IntegrityComplete = "complete"
+
+	wipeChunkSize      = 4 << 20
+	wipeCheckpointSize = 1 << 30
+)
+
+// IntegrityState is the progress of the initial wipe of a dm-integrity
+// device, kept in a LUKS token so that an interrupted wipe can be resumed
+// on the next boot.
+type IntegrityState struct {
+	State  string
+	Offset int64
+}
+
+func GetIntegrityState(devicePath string) (*IntegrityState, error) {
+	var data map[string]string
+	if err := NewTokenStore(devicePath).Get(IntegrityTokenType, &data); err != nil {
+		return nil, err
+	}
+
+	offset, err := strconv.ParseInt(data["offset"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wipe offset in integrity token: %w", err)
+	}
+
+	return &IntegrityState{
+		State:  data["state"],
+		Offset: offset,
+	}, nil
+}
+
+func StoreIntegrityState(devicePath string, state IntegrityState) error {
+	return NewTokenStore(devicePath).Put(IntegrityTokenType, map[string]string{
+		"state":  state.State,
+		"offset": strconv.FormatInt(state.Offset, 10),
+	})
+}
+
+// WipeIntegrity writes zeros through an opened dm-integrity mapping so that
+// every sector gets a valid integrity tag; reading a sector that was never
+// written fails with an integrity error. Progress is checkpointed in the
+// integrity token of the LUKS device, and the wipe resumes from the last
+// checkpoint when the state says it was interrupted.
+func WipeIntegrity(ctx context.Context, devicePath, mapperDevice string, offset int64) error {
+	file, err := os.OpenFile(mapperDevice, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", mapperDevice, err)
+	}
+	defer file.Close()
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to determine size of %s: %w", mapperDevice, err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek %s: %w", mapperDevice, err)
+	}
+
+	if offset > 0 {
+		log.Printf("Resuming integrity wipe of %s at %d%%", mapperDevice, offset*100/size)
+	} else {
+		log.Printf("Wiping %s to initialize integrity tags, this may take a while", mapperDevice)
+	}
+
+	zeros := make([]byte, wipeChunkSize)
+	lastCheckpoint := offset
+	for offset < size {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		chunk := zeros
+		if remaining := size - offset; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		n, err := file.Write(chunk)
+		offset += int64(n)
+		if err != nil {
+			return fmt.Errorf("failed to wipe %s at offset %d: %w", mapperDevice, offset, err)
+		}
+
+		if offset-lastCheckpoint >= wipeCheckpointSize || offset == size {
+			if err := file.Sync(); err != nil {
+				return fmt.Errorf("failed to flush %s: %w", mapperDevice, err)
+			}
+			if err := StoreIntegrityState(devicePath, IntegrityState{State: IntegrityWiping, Offset: offset}); err != nil {
+				return err
+			}
+			lastCheckpoint = offset
+			log.Printf("Integrity wipe of %s: %d%%", mapperDevice, offset*100/size)
+		}
+	}
+
+	return StoreIntegrityState(devicePath, IntegrityState{State: IntegrityComplete, Offset: size})
+}
+
+// IntegrityErrors returns the kernel log messages reporting integrity
+// failures on a mapping or on the dm-integrity device underneath it.
+func IntegrityErrors(mapperName string) ([]string, error) {
+	var dmNames []string
+	for _, name := range []string{mapperName, mapperName + "_dif"} {
+		if target, err := filepath.EvalSymlinks(filepath.Join("/dev/mapper", name)); err == nil {
+			dmNames = append(dmNames, filepath.Base(target))
+		}
+	}
+	if len(dmNames) == 0 {
+		return nil, fmt.Errorf("mapping %s is not active", mapperName)
+	}
+
+	output, err := exec.Command("dmesg").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kernel log: %w", err)
+	}
+
+	var errors []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if !strings.Contains(strings.ToLower(line), "integrity") {
+			continue
+		}
+		for _, dmName := range dmNames {
+			if strings.Contains(line, dmName+":") {
+				errors = append(errors, strings.TrimSpace(line))
+				break
+			}
+		}
+	}
+
+	return errors, nil
+}
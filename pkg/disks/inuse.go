@@ -0,0 +1,298 @@
+package disks
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var devNumberPattern = regexp.MustCompile(`^\d+:\d+$`)
+
+type deviceUse struct {
+	owner  string
+	reason string
+}
+
+// InUseDetector takes a snapshot of every block device the running system
+// depends on: mounted filesystems (including overlay layers), active swap
+// and device-mapper tables. Uses are recorded against the device that is
+// mounted or mapped and every device it is built from, found through the
+// slaves links. Check walks partitions and holders from a candidate device
+// up to them, and its slaves down to the disks it is built on, so that a
+// disk backing root through LVM, dm-crypt or md RAID is found as well as a
+// dm or md candidate sharing a disk with root.
+type InUseDetector struct {
+	uses map[string][]deviceUse
+}
+
+func NewInUseDetector() (*InUseDetector, error) {
+	d := &InUseDetector{
+		uses: make(map[string][]deviceUse),
+	}
+
+	if err := d.scanMounts(); err != nil {
+		return nil, fmt.Errorf("failed to read mounts: %w", err)
+	}
+	if err := d.scanSwaps(); err != nil {
+		return nil, fmt.Errorf("failed to read swaps: %w", err)
+	}
+	d.scanDeviceMapper()
+	d.markSlaves()
+
+	return d, nil
+}
+
+// Check returns an error describing how the device is in use. Uses whose
+// owner (a mount point, swap file or device-mapper name) is listed in owned
+// are ignored, which lets a disk that tdx-init itself opened and mounted on
+// a previous run be resolved again.
+func (d *InUseDetector) Check(device string, owned ...string) error {
+	isOwned := func(owner string) bool {
+		for _, o := range owned {
+			if o != "" && o == owner {
+				return true
+			}
+		}
+		return false
+	}
+
+	seen := make(map[string]bool)
+	queue := []string{canonicalDeviceName(device)}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		for _, use := range d.uses[name] {
+			if !isOwned(use.owner) {
+				return fmt.Errorf("%s is in use: %s", device, use.reason)
+			}
+		}
+
+		queue = append(queue, partitionNames(name)...)
+		for _, holder := range holderNames(name) {
+			dmName := readSysfsAttr(holder, "dm/name")
+			if dmName != "" && isOwned(dmName) {
+				queue = append(queue, holder)
+				continue
+			}
+			return fmt.Errorf("%s is in use: held by %s", device, holderLabel(holder, dmName))
+		}
+	}
+
+	// The holders of the disks below the candidate include the candidate
+	// itself, so only recorded uses are checked there
+	for _, slave := range lowerDevices(canonicalDeviceName(device)) {
+		disk := parentDisk(slave)
+		for _, name := range append([]string{slave, disk}, partitionNames(disk)...) {
+			for _, use := range d.uses[name] {
+				if !isOwned(use.owner) {
+					return fmt.Errorf("%s is in use: built on %s, which is %s", device, slave, use.reason)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// markSlaves records the uses of every device against the devices below it
+// as well, so that a partition under an LVM volume or RAID array mounted
+// as root is in use like the volume itself.
+func (d *InUseDetector) markSlaves() {
+	direct := make(map[string][]deviceUse, len(d.uses))
+	for name, uses := range d.uses {
+		direct[name] = uses
+	}
+	for name, uses := range direct {
+		for _, slave := range lowerDevices(name) {
+			for _, use := range uses {
+				d.add(slave, use.owner, fmt.Sprintf("%s through %s", use.reason, name))
+			}
+		}
+	}
+}
+
+// lowerDevices follows the slaves links of a device down to the devices
+// that are not built from anything else, returning every device on the way.
+func lowerDevices(name string) []string {
+	seen := map[string]bool{name: true}
+	var lower []string
+	queue := slaveNames(name)
+	for len(queue) > 0 {
+		slave := queue[0]
+		queue = queue[1:]
+		if seen[slave] {
+			continue
+		}
+		seen[slave] = true
+		lower = append(lower, slave)
+		queue = append(queue, slaveNames(slave)...)
+	}
+	return lower
+}
+
+func (d *InUseDetector) add(name, owner, reason string) {
+	if name == "" {
+		return
+	}
+	d.uses[name] = append(d.uses[name], deviceUse{owner: owner, reason: reason})
+}
+
+// scanMounts reads /proc/self/mountinfo rather than /proc/mounts because it
+// carries the device number of every mount, which still resolves when the
+// source is shown as /dev/root or a path that no longer exists.
+func (d *InUseDetector) scanMounts() error {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || len(fields) < sep+4 {
+			continue
+		}
+
+		mountPoint := unescapeMountField(fields[4])
+		fsType := fields[sep+1]
+		source := unescapeMountField(fields[sep+2])
+		superOptions := fields[sep+3]
+		reason := fmt.Sprintf("mounted at %s", mountPoint)
+
+		d.add(blockNameForDevNumber(fields[2]), mountPoint, reason)
+		if strings.HasPrefix(source, "/dev/") {
+			d.add(blockNameForPath(source), mountPoint, reason)
+		}
+
+		// Overlay mounts have no backing device of their own; the devices
+		// holding their layers are in use instead.
+		if fsType == "overlay" {
+			for _, option := range strings.Split(superOptions, ",") {
+				key, value, ok := strings.Cut(option, "=")
+				if !ok || (key != "lowerdir" && key != "upperdir" && key != "workdir") {
+					continue
+				}
+				for _, dir := range strings.Split(value, ":") {
+					d.add(blockNameForFile(unescapeMountField(dir)), mountPoint, reason)
+				}
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (d *InUseDetector) scanSwaps() error {
+	file, err := os.Open("/proc/swaps")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] == "Filename" {
+			continue
+		}
+
+		swap := unescapeMountField(fields[0])
+		reason := fmt.Sprintf("active swap %s", swap)
+		if fields[1] == "partition" {
+			d.add(blockNameForPath(swap), swap, reason)
+		} else {
+			d.add(blockNameForFile(swap), swap, reason)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// scanDeviceMapper records the devices referenced by active device-mapper
+// tables. Systems without dmsetup have no tables to inspect beyond what the
+// holders links in sysfs already show, so failures are ignored.
+func (d *InUseDetector) scanDeviceMapper() {
+	output, err := exec.Command("dmsetup", "table").Output()
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		dmName, table, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		for _, field := range strings.Fields(table) {
+			if !devNumberPattern.MatchString(field) {
+				continue
+			}
+			reason := fmt.Sprintf("used by device-mapper table %s", dmName)
+			d.add(blockNameForDevNumber(field), dmName, reason)
+		}
+	}
+}
+
+func holderLabel(holder, dmName string) string {
+	if dmName != "" {
+		return fmt.Sprintf("%s (%s)", holder, dmName)
+	}
+	return holder
+}
+
+func blockNameForPath(path string) string {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return ""
+	}
+	return blockNameForDev(uint64(st.Rdev))
+}
+
+func blockNameForFile(path string) string {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return ""
+	}
+	return blockNameForDev(uint64(st.Dev))
+}
+
+// unescapeMountField decodes the octal escapes (\040 for space and so on)
+// used in /proc/self/mountinfo and /proc/swaps.
+func unescapeMountField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+4 <= len(field) {
+			if n, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -15,14 +16,25 @@ func NewLargestDiskFinder() *LargestDiskFinder {
 }
 
 func (f *LargestDiskFinder) Find() (string, error) {
-	file, err := os.Open("/proc/partitions")
+	candidates, err := f.Candidates()
 	if err != nil {
 		return "", err
 	}
+	return firstUnused(candidates)
+}
+
+func (f *LargestDiskFinder) Candidates() ([]string, error) {
+	file, err := os.Open("/proc/partitions")
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
 
-	var largestDevice string
-	var largestSize int64
+	type disk struct {
+		device string
+		size   int64
+	}
+	var disks []disk
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
@@ -53,19 +65,22 @@ func (f *LargestDiskFinder) Find() (string, error) {
 
 		sizeBytes := sizeBlocks * 1024
 
-		if sizeBytes > largestSize {
-			largestSize = sizeBytes
-			largestDevice = "/dev/" + deviceName
-		}
+		disks = append(disks, disk{device: "/dev/" + deviceName, size: sizeBytes})
 	}
 
 	if err := scanner.Err(); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if largestDevice == "" {
-		return "", fmt.Errorf("no SCSI disk found")
+	if len(disks) == 0 {
+		return nil, fmt.Errorf("no SCSI disk found")
 	}
 
-	return largestDevice, nil
-}
\ No newline at end of file
+	sort.SliceStable(disks, func(i, j int) bool { return disks[i].size > disks[j].size })
+
+	var candidates []string
+	for _, d := range disks {
+		candidates = append(candidates, d.device)
+	}
+	return candidates, nil
+}
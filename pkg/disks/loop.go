@@ -0,0 +1,154 @@
+package disks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Loop device ioctls from <linux/loop.h>.
+const (
+	loopSetFD       = 0x4C00
+	loopClrFD       = 0x4C01
+	loopSetStatus64 = 0x4C04
+	loopSetCapacity = 0x4C07
+	loopCtlGetFree  = 0x4C82
+
+	loFlagsPartScan = 8
+	loNameSize      = 64
+	loKeySize       = 32
+)
+
+type loopInfo64 struct {
+	Device         uint64
+	Inode          uint64
+	Rdevice        uint64
+	Offset         uint64
+	SizeLimit      uint64
+	Number         uint32
+	EncryptType    uint32
+	EncryptKeySize uint32
+	Flags          uint32
+	FileName       [loNameSize]byte
+	CryptName      [loNameSize]byte
+	EncryptKey     [loKeySize]byte
+	Init           [2]uint64
+}
+
+func loopIoctl(fd uintptr, request, arg uintptr) (uintptr, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+	if errno != 0 {
+		return 0, errno
+	}
+	return r, nil
+}
+
+// AttachLoopDevice binds a file to a free loop device and returns the device
+// path. Partition scanning is enabled so that partition layouts work on
+// image files like on real disks.
+func AttachLoopDevice(path string) (string, error) {
+	control, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to open loop control: %w", err)
+	}
+	defer control.Close()
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to open image file: %w", err)
+	}
+	defer file.Close()
+
+	// Another process may grab the free device between the two ioctls, in
+	// which case LOOP_SET_FD fails with EBUSY and we ask again
+	for attempt := 0; attempt < 10; attempt++ {
+		n, err := loopIoctl(control.Fd(), loopCtlGetFree, 0)
+		if err != nil {
+			return "", fmt.Errorf("failed to get a free loop device: %w", err)
+		}
+
+		device := fmt.Sprintf("/dev/loop%d", n)
+		loop, err := os.OpenFile(device, os.O_RDWR, 0)
+		if err != nil {
+			return "", fmt.Errorf("failed to open %s: %w", device, err)
+		}
+
+		if _, err := loopIoctl(loop.Fd(), loopSetFD, file.Fd()); err != nil {
+			loop.Close()
+			if err == syscall.EBUSY {
+				continue
+			}
+			return "", fmt.Errorf("failed to attach %s to %s: %w", path, device, err)
+		}
+
+		info := loopInfo64{Flags: loFlagsPartScan}
+		copy(info.FileName[:loNameSize-1], path)
+		if _, err := loopIoctl(loop.Fd(), loopSetStatus64, uintptr(unsafe.Pointer(&info))); err != nil {
+			loopIoctl(loop.Fd(), loopClrFD, 0)
+			loop.Close()
+			return "", fmt.Errorf("failed to configure %s: %w", device, err)
+		}
+
+		loop.Close()
+		return device, nil
+	}
+
+	return "", fmt.Errorf("failed to attach %s: no free loop device", path)
+}
+
+// DetachLoopDevice unbinds a loop device from its file. The kernel defers
+// the detach until the device is no longer open.
+func DetachLoopDevice(device string) error {
+	loop, err := os.OpenFile(device, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", device, err)
+	}
+	defer loop.Close()
+
+	if _, err := loopIoctl(loop.Fd(), loopClrFD, 0); err != nil && err != syscall.ENXIO {
+		return fmt.Errorf("failed to detach %s: %w", device, err)
+	}
+	return nil
+}
+
+// RefreshLoopCapacity makes a loop device pick up the new size of its file.
+func RefreshLoopCapacity(device string) error {
+	loop, err := os.OpenFile(device, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", device, err)
+	}
+	defer loop.Close()
+
+	if _, err := loopIoctl(loop.Fd(), loopSetCapacity, 0); err != nil {
+		return fmt.Errorf("failed to update capacity of %s: %w", device, err)
+	}
+	return nil
+}
+
+// FindLoopDevice returns the loop device the file is attached to, or an
+// empty string if it is not attached.
+func FindLoopDevice(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	names, err := listBlockDevices()
+	if err != nil {
+		return "", fmt.Errorf("failed to list block devices: %w", err)
+	}
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, "loop") {
+			continue
+		}
+		if readSysfsAttr(name, "loop/backing_file") == path {
+			return "/dev/" + name, nil
+		}
+	}
+
+	return "", nil
+}
@@ -1,48 +1,62 @@
 package disks
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
 	"strings"
+	"tdx-init/pkg/config"
 )
 
-const (
-	InitTokenID = "1"
-	SSHTokenID  = "2"
-)
-
-type Token struct {
-	Type     string            `json:"type"`
-	Keyslots []string          `json:"keyslots"`
-	UserData map[string]string `json:"user_data"`
+// IsLuksDevice reports whether a device or file carries a LUKS header.
+// LUKS1 headers and LUKS2 headers with no valid copy count too, so that such
+// a disk fails to open instead of being taken for an empty one and formatted.
+func IsLuksDevice(devicePath string) bool {
+	_, err := ReadLuks2Header(devicePath)
+	return err == nil || errors.Is(err, ErrLuks1) || errors.Is(err, ErrInvalidLuks)
 }
 
-func IsLuksDevice(devicePath string) bool {
-	cmd := exec.Command("cryptsetup", "isLuks", devicePath)
-	return cmd.Run() == nil
+// LuksOptions describe how a LUKS2 device is formatted. When Header is set
+// the header is kept in that file or device instead of on the data device,
+// and the read-only helpers and token helpers must be given the header path
+// in place of the data device. UUID, if set, is given to the new container
+// instead of a random one.
+type LuksOptions struct {
+	Params    config.LuksConfig
+	Integrity string
+	Header    string
+	UUID      string
 }
 
-func IsInitialized(devicePath string) bool {
-	cmd := exec.Command("cryptsetup", "token", "export", "--token-id", InitTokenID, devicePath)
-	output, err := cmd.Output()
-	if err != nil {
-		return false
-	}
+func FormatLuks(devicePath, passphrase string, opts LuksOptions) error {
+	log.Printf("Formatting %s with LUKS2 encryption", devicePath)
 
-	var token Token
-	if err := json.Unmarshal(output, &token); err != nil {
-		return false
+	params := opts.Params
+	if opts.Integrity == "aead" && params.Cipher == "" {
+		params.Cipher = "aes-gcm-random"
 	}
 
-	return token.UserData["initialized"] == "true"
-}
-
-func FormatLuks(devicePath, passphrase string) error {
-	log.Printf("Formatting %s with LUKS2 encryption", devicePath)
+	args := []string{"luksFormat", "--type", "luks2", "-q"}
+	args = append(args, luksFormatArgs(params)...)
+	if opts.Integrity != "" {
+		// The initial wipe that computes the integrity tags is done by
+		// WipeIntegrity so that it can be checkpointed and resumed.
+		args = append(args, "--integrity", opts.Integrity, "--integrity-no-wipe")
+	}
+	if opts.UUID != "" {
+		args = append(args, "--uuid", opts.UUID)
+	}
+	if opts.Header != "" {
+		if err := createHeaderFile(opts.Header); err != nil {
+			return err
+		}
+		args = append(args, "--header", opts.Header)
+	}
+	args = append(args, devicePath)
 
-	cmd := exec.Command("cryptsetup", "luksFormat", "--type", "luks2", "-q", devicePath)
+	cmd := exec.Command("cryptsetup", args...)
 	cmd.Stdin = strings.NewReader(passphrase)
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to format with LUKS: %w", err)
@@ -51,8 +65,14 @@ func FormatLuks(devicePath, passphrase string) error {
 	return nil
 }
 
-func OpenLuks(devicePath, mapperName, passphrase string) error {
-	cmd := exec.Command("cryptsetup", "open", devicePath, mapperName)
+func OpenLuks(devicePath, mapperName, passphrase, header string) error {
+	args := []string{"open"}
+	if header != "" {
+		args = append(args, "--header", header)
+	}
+	args = append(args, devicePath, mapperName)
+
+	cmd := exec.Command("cryptsetup", args...)
 	cmd.Stdin = strings.NewReader(passphrase)
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to open LUKS device: %w", err)
@@ -64,66 +84,93 @@ func CloseLuks(mapperName string) error {
 	return exec.Command("cryptsetup", "close", mapperName).Run()
 }
 
-func StoreInitToken(devicePath, diskName string) error {
-	token := Token{
-		Type:     "tdx-init",
-		Keyslots: []string{},
-		UserData: map[string]string{
-			"initialized": "true",
-			"disk_name":   diskName,
-		},
+// LuksUUID reads the UUID of a LUKS container. Headers the native reader
+// rejects, LUKS1 or with no valid LUKS2 copy, are left to cryptsetup.
+func LuksUUID(devicePath string) (string, error) {
+	hdr, err := ReadLuks2Header(devicePath)
+	if err == nil {
+		return hdr.UUID, nil
 	}
-
-	tokenJSON, err := json.Marshal(token)
-	if err != nil {
-		return fmt.Errorf("failed to marshal init token: %w", err)
+	if !errors.Is(err, ErrLuks1) && !errors.Is(err, ErrInvalidLuks) {
+		return "", err
 	}
-
-	cmd := exec.Command("cryptsetup", "token", "import", "--token-id", InitTokenID, devicePath)
-	cmd.Stdin = strings.NewReader(string(tokenJSON))
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to store init token: %w", err)
+	output, cerr := exec.Command("cryptsetup", "luksUUID", devicePath).Output()
+	if cerr != nil {
+		return "", err
 	}
+	return strings.TrimSpace(string(output)), nil
+}
 
+func SetLuksUUID(devicePath, uuid string) error {
+	if output, err := exec.Command("cryptsetup", "luksUUID", "-q", "--uuid", uuid, devicePath).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set LUKS UUID of %s: %w (output: %s)", devicePath, err, strings.TrimSpace(string(output)))
+	}
 	return nil
 }
 
-func StoreSSHToken(devicePath, sshKey string) error {
-	token := Token{
-		Type:     "ssh-key",
-		Keyslots: []string{},
-		UserData: map[string]string{
-			"ssh_key": sshKey,
-		},
+// BackupLuksHeader writes a copy of the header to backupPath, replacing any
+// previous backup only once the new one is complete.
+func BackupLuksHeader(devicePath, backupPath string) error {
+	tmpPath := backupPath + ".tmp"
+	os.Remove(tmpPath)
+
+	cmd := exec.Command("cryptsetup", "luksHeaderBackup", devicePath, "--header-backup-file", tmpPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to back up LUKS header: %w (output: %s)", err, strings.TrimSpace(string(output)))
 	}
 
-	tokenJSON, err := json.Marshal(token)
-	if err != nil {
-		return fmt.Errorf("failed to marshal SSH token: %w", err)
+	if err := os.Rename(tmpPath, backupPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace header backup: %w", err)
 	}
 
-	cmd := exec.Command("cryptsetup", "token", "import", "--token-id", SSHTokenID, devicePath)
-	cmd.Stdin = strings.NewReader(string(tokenJSON))
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to store SSH token: %w", err)
+	return nil
+}
+
+// RestoreLuksHeader restores a header from a backup. A header file that no
+// longer exists is recreated from the backup image.
+func RestoreLuksHeader(devicePath, backupPath string) error {
+	if _, err := os.Stat(devicePath); os.IsNotExist(err) {
+		data, err := os.ReadFile(backupPath)
+		if err != nil {
+			return fmt.Errorf("failed to read header backup: %w", err)
+		}
+		if err := os.WriteFile(devicePath, data, 0600); err != nil {
+			return fmt.Errorf("failed to restore LUKS header: %w", err)
+		}
+		return nil
+	}
+
+	cmd := exec.Command("cryptsetup", "luksHeaderRestore", "-q", devicePath, "--header-backup-file", backupPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to restore LUKS header: %w (output: %s)", err, strings.TrimSpace(string(output)))
 	}
 
 	return nil
 }
 
-func GetSSHToken(devicePath string) (string, error) {
-	cmd := exec.Command("cryptsetup", "token", "export", "--token-id", SSHTokenID, devicePath)
-	output, err := cmd.Output()
+func createHeaderFile(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
-		return "", fmt.Errorf("no SSH token found")
+		return fmt.Errorf("failed to create header file: %w", err)
 	}
+	return file.Close()
+}
 
-	var token Token
-	if err := json.Unmarshal(output, &token); err != nil {
-		return "", fmt.Errorf("failed to parse SSH token: %w", err)
+func StoreSSHToken(devicePath, sshKey string) error {
+	return NewTokenStore(devicePath).Put(SSHTokenType, map[string]string{
+		"ssh_key": sshKey,
+	})
+}
+
+func GetSSHToken(devicePath string) (string, error) {
+	var data map[string]string
+	if err := NewTokenStore(devicePath).Get(SSHTokenType, &data); err != nil {
+		return "", err
 	}
 
-	key, ok := token.UserData["ssh_key"]
+	key, ok := data["ssh_key"]
 	if !ok {
 		return "", fmt.Errorf("no SSH key in token")
 	}
@@ -0,0 +1,228 @@
+package disks
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testLuksJSON = `{"keyslots":{},"tokens":{"1":{"type":"tdx-init","keyslots":[],"user_data":{"version":"2","phase":"complete"}}},"segments":{},"digests":{},"config":{"json_size":"12288","keyslots_size":"16384"}}`
+
+// writeLuks2Copy writes one copy of a LUKS2 header into img at offset.
+func writeLuks2Copy(img []byte, offset, hdrSize int64, magic []byte, seqID uint64, alg, metadata string) {
+	h := img[offset : offset+hdrSize]
+	for i := range h {
+		h[i] = 0
+	}
+	copy(h, magic)
+	binary.BigEndian.PutUint16(h[luks2VersionOffset:], 2)
+	binary.BigEndian.PutUint64(h[luks2HdrSizeOffset:], uint64(hdrSize))
+	binary.BigEndian.PutUint64(h[luks2SeqIDOffset:], seqID)
+	copy(h[luks2LabelOffset:], "data")
+	copy(h[luks2CsumAlgOffset:], alg)
+	copy(h[luks2UUIDOffset:], "0b6f3c4e-8d1a-4b2e-9f3c-1a2b3c4d5e6f")
+	binary.BigEndian.PutUint64(h[luks2HdrOffsetOffset:], uint64(offset))
+	copy(h[luks2BinaryHeaderSize:], metadata)
+
+	var sum hash.Hash = sha256.New()
+	if alg == "sha512" {
+		sum = sha512.New()
+	}
+	sum.Write(h)
+	copy(h[luks2CsumOffset:], sum.Sum(nil))
+}
+
+func TestReadLuks2Header(t *testing.T) {
+	const hdrSize = 0x4000
+	valid := func(img []byte) {
+		writeLuks2Copy(img, 0, hdrSize, luks2MagicPrimary, 5, "sha256", testLuksJSON)
+		writeLuks2Copy(img, hdrSize, hdrSize, luks2MagicSecondary, 5, "sha256", testLuksJSON)
+	}
+
+	tests := []struct {
+		name        string
+		build       func(img []byte)
+		wantSeqID   uint64
+		wantWarning string
+		wantErr     error
+		wantLuks    bool
+	}{
+		{
+			name:      "both copies valid",
+			build:     valid,
+			wantSeqID: 5,
+			wantLuks:  true,
+		},
+		{
+			name: "sha512 checksum",
+			build: func(img []byte) {
+				writeLuks2Copy(img, 0, hdrSize, luks2MagicPrimary, 5, "sha512", testLuksJSON)
+				writeLuks2Copy(img, hdrSize, hdrSize, luks2MagicSecondary, 5, "sha512", testLuksJSON)
+			},
+			wantSeqID: 5,
+			wantLuks:  true,
+		},
+		{
+			name: "larger header",
+			build: func(img []byte) {
+				writeLuks2Copy(img, 0, 0x8000, luks2MagicPrimary, 5, "sha256", testLuksJSON)
+				writeLuks2Copy(img, 0x8000, 0x8000, luks2MagicSecondary, 5, "sha256", testLuksJSON)
+			},
+			wantSeqID: 5,
+			wantLuks:  true,
+		},
+		{
+			name: "corrupted primary JSON",
+			build: func(img []byte) {
+				valid(img)
+				img[luks2BinaryHeaderSize+10] ^= 0xff
+			},
+			wantSeqID:   5,
+			wantWarning: "primary header: sha256 checksum mismatch",
+			wantLuks:    true,
+		},
+		{
+			name: "corrupted primary binary header",
+			build: func(img []byte) {
+				valid(img)
+				img[luks2UUIDOffset] ^= 0xff
+			},
+			wantSeqID:   5,
+			wantWarning: "primary header: sha256 checksum mismatch",
+			wantLuks:    true,
+		},
+		{
+			name: "primary wiped",
+			build: func(img []byte) {
+				valid(img)
+				for i := 0; i < hdrSize; i++ {
+					img[i] = 0
+				}
+			},
+			wantSeqID:   5,
+			wantWarning: "primary header: no LUKS header",
+			wantLuks:    true,
+		},
+		{
+			name: "corrupted secondary",
+			build: func(img []byte) {
+				valid(img)
+				img[hdrSize+luks2BinaryHeaderSize+10] ^= 0xff
+			},
+			wantSeqID:   5,
+			wantWarning: "secondary header: sha256 checksum mismatch",
+			wantLuks:    true,
+		},
+		{
+			name: "secondary missing",
+			build: func(img []byte) {
+				writeLuks2Copy(img, 0, hdrSize, luks2MagicPrimary, 5, "sha256", testLuksJSON)
+			},
+			wantSeqID:   5,
+			wantWarning: "secondary header: no LUKS header",
+			wantLuks:    true,
+		},
+		{
+			name: "secondary newer",
+			build: func(img []byte) {
+				writeLuks2Copy(img, 0, hdrSize, luks2MagicPrimary, 5, "sha256", testLuksJSON)
+				writeLuks2Copy(img, hdrSize, hdrSize, luks2MagicSecondary, 6, "sha256", testLuksJSON)
+			},
+			wantSeqID:   6,
+			wantWarning: "primary header is outdated",
+			wantLuks:    true,
+		},
+		{
+			name: "primary newer",
+			build: func(img []byte) {
+				writeLuks2Copy(img, 0, hdrSize, luks2MagicPrimary, 7, "sha256", testLuksJSON)
+				writeLuks2Copy(img, hdrSize, hdrSize, luks2MagicSecondary, 6, "sha256", testLuksJSON)
+			},
+			wantSeqID:   7,
+			wantWarning: "secondary header is outdated",
+			wantLuks:    true,
+		},
+		{
+			name: "secondary at wrong offset",
+			build: func(img []byte) {
+				valid(img)
+				binary.BigEndian.PutUint64(img[hdrSize+luks2HdrOffsetOffset:], 0)
+			},
+			wantSeqID:   5,
+			wantWarning: "secondary header: header at 16384 claims offset 0",
+			wantLuks:    true,
+		},
+		{
+			name: "both copies corrupted",
+			build: func(img []byte) {
+				valid(img)
+				img[luks2BinaryHeaderSize+10] ^= 0xff
+				img[hdrSize+luks2BinaryHeaderSize+10] ^= 0xff
+			},
+			wantErr:  ErrInvalidLuks,
+			wantLuks: true,
+		},
+		{
+			name:    "no header",
+			build:   func(img []byte) {},
+			wantErr: ErrNotLuks,
+		},
+		{
+			name: "LUKS1",
+			build: func(img []byte) {
+				copy(img, luks2MagicPrimary)
+				binary.BigEndian.PutUint16(img[luks2VersionOffset:], 1)
+			},
+			wantErr:  ErrLuks1,
+			wantLuks: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := make([]byte, 0x20000)
+			tt.build(img)
+			path := filepath.Join(t.TempDir(), "header.img")
+			if err := os.WriteFile(path, img, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := IsLuksDevice(path); got != tt.wantLuks {
+				t.Errorf("IsLuksDevice() = %v, want %v", got, tt.wantLuks)
+			}
+
+			hdr, err := ReadLuks2Header(path)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ReadLuks2Header() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadLuks2Header() error = %v", err)
+			}
+
+			if hdr.SeqID != tt.wantSeqID {
+				t.Errorf("SeqID = %d, want %d", hdr.SeqID, tt.wantSeqID)
+			}
+			switch {
+			case tt.wantWarning == "" && len(hdr.Warnings) > 0:
+				t.Errorf("Warnings = %q, want none", hdr.Warnings)
+			case tt.wantWarning != "" && (len(hdr.Warnings) != 1 || !strings.Contains(hdr.Warnings[0], tt.wantWarning)):
+				t.Errorf("Warnings = %q, want %q", hdr.Warnings, tt.wantWarning)
+			}
+			if hdr.UUID != "0b6f3c4e-8d1a-4b2e-9f3c-1a2b3c4d5e6f" || hdr.Metadata.Label != "data" {
+				t.Errorf("UUID, Label = %q, %q", hdr.UUID, hdr.Metadata.Label)
+			}
+			if hdr.Metadata.Config.JSONSize != "12288" || len(hdr.Metadata.Tokens) != 1 {
+				t.Errorf("Metadata = %+v", hdr.Metadata)
+			}
+		})
+	}
+}
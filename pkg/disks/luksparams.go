@@ -0,0 +1,218 @@
+package disks
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"tdx-init/pkg/config"
+)
+
+type LuksMetadata struct {
+	Keyslots map[string]LuksKeyslot     `json:"keyslots"`
+	Tokens   map[string]json.RawMessage `json:"tokens"`
+	Segments map[string]LuksSegment     `json:"segments"`
+	Digests  map[string]LuksDigest      `json:"digests"`
+	Config   LuksConfigArea             `json:"config"`
+
+	// Label and Subsystem live in the binary header, not in the JSON area.
+	Label     string `json:"-"`
+	Subsystem string `json:"-"`
+}
+
+type LuksKeyslot struct {
+	Type    string  `json:"type"`
+	KeySize int     `json:"key_size"`
+	KDF     LuksKDF `json:"kdf"`
+}
+
+type LuksKDF struct {
+	Type       string `json:"type"`
+	Hash       string `json:"hash"`
+	Iterations int    `json:"iterations"`
+	Time       int    `json:"time"`
+	Memory     int    `json:"memory"`
+	CPUs       int    `json:"cpus"`
+}
+
+type LuksSegment struct {
+	Type       string                `json:"type"`
+	Offset     string                `json:"offset"`
+	Size       string                `json:"size"`
+	Encryption string                `json:"encryption"`
+	SectorSize int                   `json:"sector_size"`
+	Integrity  *LuksSegmentIntegrity `json:"integrity"`
+}
+
+type LuksSegmentIntegrity struct {
+	Type string `json:"type"`
+}
+
+type LuksDigest struct {
+	Type string `json:"type"`
+	Hash string `json:"hash"`
+}
+
+type LuksConfigArea struct {
+	JSONSize     string `json:"json_size"`
+	KeyslotsSize string `json:"keyslots_size"`
+}
+
+// luksFormatArgs translates the configured LUKS2 parameters into
+// cryptsetup luksFormat arguments. Unset parameters keep cryptsetup's
+// defaults.
+func luksFormatArgs(params config.LuksConfig) []string {
+	var args []string
+	if params.Cipher != "" {
+		args = append(args, "--cipher", params.Cipher)
+	}
+	if params.KeySize > 0 {
+		args = append(args, "--key-size", fmt.Sprint(params.KeySize))
+	}
+	if params.Hash != "" {
+		args = append(args, "--hash", params.Hash)
+	}
+	if params.PBKDF != "" {
+		args = append(args, "--pbkdf", params.PBKDF)
+	}
+	if params.PBKDFMemory > 0 {
+		args = append(args, "--pbkdf-memory", fmt.Sprint(params.PBKDFMemory))
+	}
+	if params.PBKDFTime > 0 {
+		args = append(args, "--pbkdf-force-iterations", fmt.Sprint(params.PBKDFTime))
+	}
+	if params.PBKDFParallel > 0 {
+		args = append(args, "--pbkdf-parallel", fmt.Sprint(params.PBKDFParallel))
+	}
+	if params.SectorSize > 0 {
+		args = append(args, "--sector-size", fmt.Sprint(params.SectorSize))
+	}
+	if params.Label != "" {
+		args = append(args, "--label", params.Label)
+	}
+	if params.Subsystem != "" {
+		args = append(args, "--subsystem", params.Subsystem)
+	}
+	return args
+}
+
+func DumpLuksMetadata(devicePath string) (*LuksMetadata, error) {
+	hdr, err := ReadLuks2Header(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	return &hdr.Metadata, nil
+}
+
+// CheckLuksParams compares the configured parameters against an existing
+// header and describes every difference. Only parameters that are set in
+// the configuration are compared.
+func CheckLuksParams(meta *LuksMetadata, opts LuksOptions) []string {
+	params := opts.Params
+	var drift []string
+	mismatch := func(what string, want, got interface{}) {
+		drift = append(drift, fmt.Sprintf("%s is %v, policy requires %v", what, got, want))
+	}
+
+	for _, id := range sortedKeys(meta.Segments) {
+		segment := meta.Segments[id]
+		if params.Cipher != "" && segment.Encryption != params.Cipher {
+			mismatch("segment "+id+" cipher", params.Cipher, segment.Encryption)
+		}
+		if params.SectorSize > 0 && segment.SectorSize != params.SectorSize {
+			mismatch("segment "+id+" sector size", params.SectorSize, segment.SectorSize)
+		}
+		integrity := ""
+		if segment.Integrity != nil {
+			integrity = segment.Integrity.Type
+		}
+		if integrity != luksIntegrityType(opts.Integrity) {
+			mismatch("segment "+id+" integrity", luksIntegrityType(opts.Integrity), integrity)
+		}
+	}
+
+	for _, id := range sortedKeys(meta.Digests) {
+		digest := meta.Digests[id]
+		if params.Hash != "" && digest.Hash != params.Hash {
+			mismatch("digest "+id+" hash", params.Hash, digest.Hash)
+		}
+	}
+
+	for _, id := range sortedKeys(meta.Keyslots) {
+		slot := meta.Keyslots[id]
+		what := "keyslot " + id
+		// With integrity the keyslot also holds the integrity key, so its
+		// size no longer equals the configured encryption key size.
+		if params.KeySize > 0 && opts.Integrity == "" && slot.KeySize*8 != params.KeySize {
+			mismatch(what+" key size", params.KeySize, slot.KeySize*8)
+		}
+		if params.PBKDF != "" && slot.KDF.Type != params.PBKDF {
+			mismatch(what+" pbkdf", params.PBKDF, slot.KDF.Type)
+		}
+		if params.PBKDFMemory > 0 && slot.KDF.Memory != params.PBKDFMemory {
+			mismatch(what+" pbkdf memory", params.PBKDFMemory, slot.KDF.Memory)
+		}
+		if params.PBKDFParallel > 0 && slot.KDF.CPUs != params.PBKDFParallel {
+			mismatch(what+" pbkdf parallelism", params.PBKDFParallel, slot.KDF.CPUs)
+		}
+		if params.PBKDFTime > 0 {
+			cost := slot.KDF.Time
+			if slot.KDF.Type == "pbkdf2" {
+				cost = slot.KDF.Iterations
+			}
+			if cost != params.PBKDFTime {
+				mismatch(what+" pbkdf time cost", params.PBKDFTime, cost)
+			}
+		}
+	}
+
+	if params.Label != "" && meta.Label != params.Label {
+		mismatch("label", params.Label, meta.Label)
+	}
+	if params.Subsystem != "" && meta.Subsystem != params.Subsystem {
+		mismatch("subsystem", params.Subsystem, meta.Subsystem)
+	}
+
+	return drift
+}
+
+// ReadLuksParams collects the parameters recorded in a LUKS2 header, from
+// its first segment, digest and keyslot.
+func ReadLuksParams(meta *LuksMetadata) LuksParams {
+	var params LuksParams
+	if ids := sortedKeys(meta.Segments); len(ids) > 0 {
+		segment := meta.Segments[ids[0]]
+		params.Cipher = segment.Encryption
+		params.SectorSize = segment.SectorSize
+		if segment.Integrity != nil {
+			params.Integrity = segment.Integrity.Type
+		}
+	}
+	if ids := sortedKeys(meta.Digests); len(ids) > 0 {
+		params.Hash = meta.Digests[ids[0]].Hash
+	}
+	if ids := sortedKeys(meta.Keyslots); len(ids) > 0 {
+		slot := meta.Keyslots[ids[0]]
+		params.KeySize = slot.KeySize * 8
+		params.PBKDF = slot.KDF.Type
+	}
+	return params
+}
+
+// luksIntegrityType maps the configured integrity mode to the algorithm name
+// recorded in the LUKS2 segment, e.g. hmac-sha256 to hmac(sha256).
+func luksIntegrityType(integrity string) string {
+	if alg, ok := strings.CutPrefix(integrity, "hmac-"); ok {
+		return "hmac(" + alg + ")"
+	}
+	return integrity
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
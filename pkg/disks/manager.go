@@ -4,13 +4,24 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"strings"
+	"sync"
 	"tdx-init/pkg/config"
 	"tdx-init/pkg/keys"
+	"tdx-init/pkg/version"
+	"time"
 )
 
 type Manager struct {
 	disks      map[string]*ManagedDisk
 	keyManager *keys.Manager
+	keys       map[string]config.KeyConfig
+
+	// mu guards device resolution, which SetupDisk may run from several
+	// goroutines when disks are set up concurrently
+	mu      sync.Mutex
+	claimed map[string]string
 }
 
 type ManagedDisk struct {
@@ -20,29 +31,103 @@ type ManagedDisk struct {
 	MapperName   string
 	MapperDevice string
 	Initialized  bool
+	Partitions   []*ManagedDisk
+
+	// Phase is the last completed initialization phase of an encrypted
+	// disk, empty for disks without a LUKS container, and InitToken the
+	// content of its init token, if it has one.
+	Phase     string
+	InitToken *InitToken
+
+	// Fsck is the outcome of the filesystem check of this boot, if any, and
+	// ReadOnly is set when errors made the policy mount it read-only.
+	Fsck     string
+	ReadOnly bool
+
+	// attachedLoop is set when resolving the disk attached its image file,
+	// which must then be detached if the disk is never set up
+	attachedLoop bool
 }
 
 func NewManager(cfg *config.Config, km *keys.Manager) (*Manager, error) {
 	dm := &Manager{
 		disks:      make(map[string]*ManagedDisk),
 		keyManager: km,
+		keys:       cfg.Keys,
+		claimed:    make(map[string]string),
 	}
 
 	for name, diskCfg := range cfg.Disks {
-		mapperName := fmt.Sprintf("crypt_%s", name)
-		md := &ManagedDisk{
-			Name:         name,
-			Config:       diskCfg,
-			MapperName:   mapperName,
-			MapperDevice: fmt.Sprintf("/dev/mapper/%s", mapperName),
-			Initialized:  false,
+		md := newManagedDisk(name, fmt.Sprintf("crypt_%s", name), diskCfg)
+
+		// Each partition is set up like a disk of its own, inheriting every
+		// setting of the parent except its key and mount point. The
+		// filesystem is labeled after the partition.
+		for _, part := range diskCfg.Partitions {
+			partCfg := diskCfg
+			partCfg.Partitions = nil
+			partCfg.EncryptionKey = part.EncryptionKey
+			partCfg.MountAt = part.MountAt
+			partCfg.Label = part.Label
+
+			partName := fmt.Sprintf("%s/%s", name, part.Label)
+			mapperName := fmt.Sprintf("crypt_%s_%s", name, part.Label)
+			md.Partitions = append(md.Partitions, newManagedDisk(partName, mapperName, partCfg))
 		}
+
 		dm.disks[name] = md
 	}
 
 	return dm, nil
 }
 
+func newManagedDisk(name, mapperName string, cfg config.DiskConfig) *ManagedDisk {
+	return &ManagedDisk{
+		Name:         name,
+		Config:       cfg,
+		MapperName:   mapperName,
+		MapperDevice: fmt.Sprintf("/dev/mapper/%s", mapperName),
+		Initialized:  false,
+	}
+}
+
+func (md *ManagedDisk) luksOptions() LuksOptions {
+	return LuksOptions{
+		Params:    md.Config.Luks,
+		Integrity: md.Config.Integrity,
+		Header:    md.Config.Header.Path,
+		UUID:      md.Config.Identity.UUID,
+	}
+}
+
+// MetadataDevice returns the device or file whose LUKS header carries the
+// tokens of the disk: the detached header if one is configured, the first
+// encrypted partition for partitioned disks, or the device itself.
+func (md *ManagedDisk) MetadataDevice() string {
+	if len(md.Partitions) == 0 {
+		if md.Config.Header.Path != "" {
+			return md.Config.Header.Path
+		}
+		return md.DevicePath
+	}
+	for _, part := range md.Partitions {
+		if part.Config.EncryptionKey != "" {
+			return part.DevicePath
+		}
+	}
+	return ""
+}
+
+// owned lists the mapper names and mount points this disk creates, which
+// are expected to hold its device when tdx-init runs again.
+func (md *ManagedDisk) owned() []string {
+	owned := []string{md.MapperName, md.Config.MountAt}
+	for _, part := range md.Partitions {
+		owned = append(owned, part.owned()...)
+	}
+	return owned
+}
+
 func (dm *Manager) SetupDisk(ctx context.Context, name string) error {
 	disk, ok := dm.disks[name]
 	if !ok {
@@ -50,28 +135,83 @@ func (dm *Manager) SetupDisk(ctx context.Context, name string) error {
 	}
 
 	// Find the physical device
-	devicePath, err := dm.findDevice(disk.Config)
-	if err != nil {
-		return fmt.Errorf("failed to find device for disk %s: %w", name, err)
+	if err := dm.ResolveDisks(ctx, []string{name}); err != nil {
+		return err
 	}
-	disk.DevicePath = devicePath
 
-	log.Printf("Setting up disk %s at device %s", name, devicePath)
+	log.Printf("Setting up disk %s at device %s", name, disk.DevicePath)
+
+	if len(disk.Partitions) > 0 {
+		return dm.setupPartitions(ctx, disk)
+	}
+	return dm.setupVolume(ctx, disk, false)
+}
+
+// setupVolume formats or opens and mounts a single volume, either a whole
+// disk or one partition of it. A freshly created volume is always formatted.
+func (dm *Manager) setupVolume(ctx context.Context, disk *ManagedDisk, fresh bool) error {
+	name := disk.Name
+	metadataDevice := disk.MetadataDevice()
+
+	if disk.Config.Role == "swap" || disk.Config.Format == "ephemeral" {
+		if err := dm.checkOverwrite(disk); err != nil {
+			return err
+		}
+	}
+
+	if disk.Config.Role == "swap" {
+		if err := dm.setupSwap(disk); err != nil {
+			return fmt.Errorf("failed to set up swap disk %s: %w", name, err)
+		}
+		return nil
+	}
+
+	// Ephemeral disks have nothing to detect: they are encrypted with a new
+	// key and formatted on every boot
+	if disk.Config.Format == "ephemeral" {
+		if err := dm.setupEphemeralDisk(disk); err != nil {
+			return fmt.Errorf("failed to set up ephemeral disk %s: %w", name, err)
+		}
+		dm.applyDirectories(disk)
+		return nil
+	}
+
+	if disk.Config.Header.Path != "" {
+		dm.restoreDetachedHeader(disk)
+	}
 
 	// Check if device has LUKS
-	isLuks := IsLuksDevice(devicePath)
+	isLuks := IsLuksDevice(metadataDevice)
 	if isLuks {
-		disk.Initialized = IsInitialized(devicePath)
-		log.Printf("Found existing LUKS container on %s (initialized: %v)", devicePath, disk.Initialized)
+		state := IsInitialized(metadataDevice)
+		disk.Phase = state.Phase
+		disk.Initialized = state.Initialized
+		disk.InitToken = state.Token
+		log.Printf("Found existing LUKS container on %s (phase: %s)", metadataDevice, disk.Phase)
 	}
 
 	// Determine if we should format
-	shouldFormat := dm.shouldFormat(disk, isLuks)
-	
+	shouldFormat := fresh || dm.shouldFormat(disk, isLuks)
+
+	// A freshly partitioned disk has nothing left to identify
+	if isLuks && !fresh {
+		if err := dm.checkIdentity(disk, shouldFormat); err != nil {
+			return err
+		}
+	}
+
 	if shouldFormat {
 		if err := dm.formatDisk(ctx, disk); err != nil {
 			return fmt.Errorf("failed to format disk %s: %w", name, err)
 		}
+	} else if state := dm.pendingIntegrityWipe(disk, isLuks); state != nil {
+		if err := dm.resumeIntegrityWipe(ctx, disk, state.Offset); err != nil {
+			return fmt.Errorf("failed to resume integrity wipe of disk %s: %w", name, err)
+		}
+	} else if isLuks && !phaseReached(disk.Phase, PhaseFilesystemCreated) {
+		if err := dm.resumeInit(ctx, disk); err != nil {
+			return fmt.Errorf("failed to resume initialization of disk %s: %w", name, err)
+		}
 	} else if isLuks {
 		if err := dm.mountExistingDisk(ctx, disk); err != nil {
 			return fmt.Errorf("failed to mount existing disk %s: %w", name, err)
@@ -84,6 +224,350 @@ func (dm *Manager) SetupDisk(ctx context.Context, name string) error {
 		return fmt.Errorf("disk %s requires formatting but format strategy prevents it", name)
 	}
 
+	// A layout that fails to apply is retried on the next boot, which also
+	// finishes the initialization
+	if dm.applyDirectories(disk) && !phaseReached(disk.Phase, PhaseComplete) {
+		dm.completeInit(disk)
+	}
+	return nil
+}
+
+// applyDirectories brings the directory layout of a mounted disk in line
+// with its configuration and reports whether it succeeded.
+func (dm *Manager) applyDirectories(disk *ManagedDisk) bool {
+	dirs := disk.Config.Directories
+	if dirs == nil && disk.Config.Format != "ephemeral" {
+		dirs = defaultDirectories(disk.Config.EncryptionKey != "")
+	}
+	if err := ApplyDirectories(disk.Config.MountAt, dirs); err != nil {
+		log.Printf("Warning: Failed to apply directory layout of disk %s: %v", disk.Name, err)
+		return false
+	}
+	return true
+}
+
+// completeInit records the phases that follow the directory layout once it
+// is in place.
+func (dm *Manager) completeInit(disk *ManagedDisk) {
+	if !phaseReached(disk.Phase, PhaseLayoutApplied) {
+		if err := dm.setPhase(disk, PhaseLayoutApplied); err != nil {
+			log.Printf("Warning: %v", err)
+			return
+		}
+	}
+
+	dm.backupHeader(disk)
+
+	if err := dm.setPhase(disk, PhaseComplete); err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	log.Printf("Initialization of disk %s is complete", disk.Name)
+}
+
+// setPhase records a completed initialization phase in the init token.
+func (dm *Manager) setPhase(disk *ManagedDisk, phase string) error {
+	err := dm.updateInitToken(disk, func(token *InitToken) {
+		token.Phase = phase
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record phase %s of disk %s: %w", phase, disk.Name, err)
+	}
+	return nil
+}
+
+// updateInitToken applies update to the init token of a disk as currently
+// stored in its header.
+func (dm *Manager) updateInitToken(disk *ManagedDisk, update func(*InitToken)) error {
+	token, err := ReadInitToken(disk.MetadataDevice())
+	if err != nil {
+		return err
+	}
+	update(token)
+	if err := UpdateInitToken(disk.MetadataDevice(), token); err != nil {
+		return err
+	}
+
+	disk.InitToken = token
+	disk.Phase = token.Phase
+	disk.Initialized = token.Phase == PhaseComplete
+	return nil
+}
+
+// writeInitToken creates the init token of a newly formatted disk, recording
+// what it was set up with.
+func (dm *Manager) writeInitToken(disk *ManagedDisk) error {
+	now := time.Now()
+	token := &InitToken{
+		Version:      InitTokenVersion,
+		Phase:        PhaseTokenWritten,
+		DiskName:     disk.Name,
+		Device:       disk.DevicePath,
+		ToolVersion:  version.Version,
+		ConfigHash:   disk.Config.Hash(),
+		CreatedAt:    now,
+		LastOpenedAt: now,
+		KeyName:      disk.Config.EncryptionKey,
+		KeyStrategy:  dm.keys[disk.Config.EncryptionKey].Strategy,
+	}
+	if meta, err := DumpLuksMetadata(disk.MetadataDevice()); err == nil {
+		token.Luks = ReadLuksParams(meta)
+	} else {
+		log.Printf("Warning: Unable to record LUKS parameters of disk %s: %v", disk.Name, err)
+	}
+	if uuid, err := LuksUUID(disk.MetadataDevice()); err == nil {
+		token.DiskUUID = uuid
+	} else {
+		log.Printf("Warning: Unable to record LUKS UUID of disk %s: %v", disk.Name, err)
+	}
+
+	if err := StoreInitToken(disk.MetadataDevice(), token); err != nil {
+		return err
+	}
+	disk.InitToken = token
+	disk.Phase = PhaseTokenWritten
+	return nil
+}
+
+// checkIdentity makes sure that an existing LUKS container belongs to the
+// disk entry about to open or format it: that its init token was written
+// for this entry and this container, and that it has the pinned UUID. The
+// identity policy decides whether a mismatch is refused, adopted, or forced.
+func (dm *Manager) checkIdentity(disk *ManagedDisk, willFormat bool) error {
+	uuid, err := LuksUUID(disk.MetadataDevice())
+	if err != nil {
+		// An unreadable header is no reason to refuse replacing it
+		if willFormat || disk.Config.Identity.OnMismatch == "force" {
+			log.Printf("Warning: Cannot identify device %s for disk %s: %v", disk.DevicePath, disk.Name, err)
+			return nil
+		}
+		return err
+	}
+
+	var mismatches []string
+	if token := disk.InitToken; token != nil {
+		if token.DiskName != "" && token.DiskName != disk.Name {
+			mismatches = append(mismatches, fmt.Sprintf("it was initialized as disk %s", token.DiskName))
+		}
+		if token.DiskUUID != "" && !strings.EqualFold(token.DiskUUID, uuid) {
+			mismatches = append(mismatches, fmt.Sprintf("its init token belongs to LUKS container %s, not %s", token.DiskUUID, uuid))
+		}
+	}
+	if want := disk.Config.Identity.UUID; want != "" && !strings.EqualFold(want, uuid) {
+		mismatches = append(mismatches, fmt.Sprintf("its LUKS UUID is %s, not %s", uuid, want))
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	mismatch := strings.Join(mismatches, "; ")
+	switch policy := disk.Config.Identity.OnMismatch; {
+	case policy == "force":
+		log.Printf("Warning: Device %s does not match disk %s (%s), continuing as forced", disk.DevicePath, disk.Name, mismatch)
+	case policy == "adopt" && !willFormat:
+		log.Printf("Warning: Device %s does not match disk %s (%s), adopting it", disk.DevicePath, disk.Name, mismatch)
+	case policy == "adopt":
+		return fmt.Errorf("device %s does not match disk %s (%s); adopt keeps its data, use force to format it", disk.DevicePath, disk.Name, mismatch)
+	default:
+		return fmt.Errorf("device %s does not match disk %s (%s); set identity.on_mismatch to adopt it", disk.DevicePath, disk.Name, mismatch)
+	}
+
+	// Formatting writes a new header and token, otherwise the existing ones
+	// are taken over so that the next boot does not need the override
+	if willFormat {
+		return nil
+	}
+	if want := disk.Config.Identity.UUID; want != "" && !strings.EqualFold(want, uuid) {
+		if err := SetLuksUUID(disk.MetadataDevice(), want); err != nil {
+			return err
+		}
+		uuid = want
+	}
+	if disk.InitToken == nil {
+		return nil
+	}
+	err = dm.updateInitToken(disk, func(token *InitToken) {
+		token.DiskName = disk.Name
+		token.DiskUUID = uuid
+	})
+	if err != nil {
+		return fmt.Errorf("failed to adopt disk %s: %w", disk.Name, err)
+	}
+	return nil
+}
+
+// checkOverwrite identifies the device of a swap or ephemeral disk before it
+// is overwritten, so that a LUKS container initialized for another entry is
+// not destroyed when the finder picks a different device than last boot.
+func (dm *Manager) checkOverwrite(disk *ManagedDisk) error {
+	device := disk.MetadataDevice()
+	if !IsLuksDevice(device) {
+		return nil
+	}
+	disk.InitToken = IsInitialized(device).Token
+	defer func() { disk.InitToken = nil }()
+	return dm.checkIdentity(disk, true)
+}
+
+// recordFilesystem marks the filesystem of an opened disk as created,
+// recording its type and UUID.
+func (dm *Manager) recordFilesystem(disk *ManagedDisk) error {
+	uuid := FilesystemUUID(disk.MapperDevice)
+	err := dm.updateInitToken(disk, func(token *InitToken) {
+		token.Phase = PhaseFilesystemCreated
+		token.Filesystem = disk.Config.Filesystem
+		token.FilesystemUUID = uuid
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record filesystem of disk %s: %w", disk.Name, err)
+	}
+	return nil
+}
+
+// TeardownDisk disables swap or unmounts the filesystem of a disk and closes
+// its mapping, so that nothing is left for the host to read once the guest
+// shuts down. Disks that were never set up are skipped.
+func (dm *Manager) TeardownDisk(name string) error {
+	disk, ok := dm.disks[name]
+	if !ok {
+		return fmt.Errorf("disk %s not found", name)
+	}
+
+	var errs []string
+	if len(disk.Partitions) > 0 {
+		for i := len(disk.Partitions) - 1; i >= 0; i-- {
+			if err := dm.teardownVolume(disk.Partitions[i]); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	} else if err := dm.teardownVolume(disk); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) == 0 && disk.Config.Strategy == "file" {
+		if err := dm.detachImageFile(disk); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ReleaseDisk undoes the resolution of a disk that was never set up,
+// detaching its image file if resolving it attached the file.
+func (dm *Manager) ReleaseDisk(name string) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	disk, ok := dm.disks[name]
+	if !ok {
+		return fmt.Errorf("disk %s not found", name)
+	}
+	return dm.release(disk)
+}
+
+func (dm *Manager) release(disk *ManagedDisk) error {
+	if !disk.attachedLoop {
+		return nil
+	}
+	if err := dm.detachImageFile(disk); err != nil {
+		return err
+	}
+	disk.attachedLoop = false
+	delete(dm.claimed, canonicalDeviceName(disk.DevicePath))
+	disk.DevicePath = ""
+	return nil
+}
+
+// releaseResolved releases the disks a failed call to ResolveDisks had
+// already resolved.
+// The caller holds dm.mu.
+func (dm *Manager) releaseResolved(names []string) {
+	for _, name := range names {
+		if err := dm.release(dm.disks[name]); err != nil {
+			log.Printf("Warning: Failed to release disk %s: %v", name, err)
+		}
+	}
+}
+
+// detachImageFile releases the loop device of a file backed disk.
+func (dm *Manager) detachImageFile(disk *ManagedDisk) error {
+	path, _ := disk.Config.StrategyConfig["path"].(string)
+	device, err := FindLoopDevice(path)
+	if err != nil || device == "" {
+		return err
+	}
+	if err := DetachLoopDevice(device); err != nil {
+		return fmt.Errorf("disk %s: %w", disk.Name, err)
+	}
+	log.Printf("Detached image file %s from %s", path, device)
+	return nil
+}
+
+// TeardownPersist unmounts the persisted directories of a disk, which must
+// be released before the overlays they may be bound into.
+func (dm *Manager) TeardownPersist(name string) error {
+	disk, ok := dm.disks[name]
+	if !ok {
+		return fmt.Errorf("disk %s not found", name)
+	}
+
+	for i := len(disk.Config.Persist) - 1; i >= 0; i-- {
+		target := disk.Config.Persist[i].Target
+		if err := UnmountDevice(target); err != nil {
+			return fmt.Errorf("disk %s: failed to unmount %s: %w", name, target, err)
+		}
+	}
+	return nil
+}
+
+// SetupPersist bind-mounts the persisted directories of a disk into the root
+// filesystem. It must run after the disk and the overlays are mounted.
+func (dm *Manager) SetupPersist(name string) error {
+	disk, ok := dm.disks[name]
+	if !ok {
+		return fmt.Errorf("disk %s not found", name)
+	}
+
+	for _, entry := range disk.Config.Persist {
+		if err := PersistDirectory(disk.Config.MountAt, entry); err != nil {
+			return fmt.Errorf("failed to persist %s on disk %s: %w", entry.Target, name, err)
+		}
+		log.Printf("Persisting %s on disk %s", entry.Target, name)
+	}
+
+	return nil
+}
+
+func (dm *Manager) teardownVolume(disk *ManagedDisk) error {
+	// Bind mounts keep the disk busy, release them first
+	for i := len(disk.Config.Persist) - 1; i >= 0; i-- {
+		target := disk.Config.Persist[i].Target
+		if err := UnmountDevice(target); err != nil {
+			return fmt.Errorf("disk %s: failed to unmount %s: %w", disk.Name, target, err)
+		}
+	}
+
+	if disk.Config.Role == "swap" {
+		if err := DisableSwap(disk.MapperDevice); err != nil {
+			return fmt.Errorf("disk %s: %w", disk.Name, err)
+		}
+	} else if disk.Config.MountAt != "" && IsMounted(disk.Config.MountAt) {
+		if err := UnmountDevice(disk.Config.MountAt); err != nil {
+			return fmt.Errorf("disk %s: failed to unmount %s: %w", disk.Name, disk.Config.MountAt, err)
+		}
+	}
+
+	if _, err := os.Stat(disk.MapperDevice); err == nil {
+		if err := CloseLuks(disk.MapperName); err != nil {
+			return fmt.Errorf("disk %s: failed to close %s: %w", disk.Name, disk.MapperName, err)
+		}
+	}
+
+	log.Printf("Tore down disk %s", disk.Name)
 	return nil
 }
 
@@ -92,12 +576,207 @@ func (dm *Manager) GetDisk(name string) (*ManagedDisk, bool) {
 	return disk, ok
 }
 
-func (dm *Manager) findDevice(cfg config.DiskConfig) (string, error) {
-	finder, err := CreateDiskFinder(cfg)
+// ResolveDisks assigns a block device to each of the named disks before any
+// of them is touched, so that two entries can never end up on the same
+// device. Disks are resolved in the given order and each assignment claims
+// the device; a later disk whose finder ranks several candidates falls back
+// to the next unclaimed one, otherwise setup is aborted.
+func (dm *Manager) ResolveDisks(ctx context.Context, names []string) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	// Scanning for devices in use runs dmsetup and reads every mount, so
+	// it is only done when there is something left to resolve
+	var detector *InUseDetector
+	var resolved []string
+	for _, name := range names {
+		disk, ok := dm.disks[name]
+		if !ok {
+			dm.releaseResolved(resolved)
+			return fmt.Errorf("disk %s not found", name)
+		}
+		if disk.DevicePath != "" {
+			continue
+		}
+
+		if detector == nil {
+			var err error
+			if detector, err = NewInUseDetector(); err != nil {
+				dm.releaseResolved(resolved)
+				return fmt.Errorf("failed to inspect devices in use: %w", err)
+			}
+		}
+
+		devicePath, err := dm.findDevice(ctx, disk, detector)
+		if err != nil {
+			dm.releaseResolved(resolved)
+			return fmt.Errorf("failed to find device for disk %s: %w", name, err)
+		}
+		disk.DevicePath = devicePath
+		resolved = append(resolved, name)
+
+		log.Printf("Assigned device %s to disk %s", devicePath, name)
+	}
+
+	return nil
+}
+
+// findDevice picks the first candidate that is neither claimed by another
+// disk nor in use by the running system. Devices held only by this disk's
+// own mapper or mount point, as left behind by an earlier run, are allowed.
+// With a wait_for_device policy the lookup is retried until a usable device
+// shows up.
+func (dm *Manager) findDevice(ctx context.Context, disk *ManagedDisk, detector *InUseDetector) (string, error) {
+	finder, err := CreateDiskFinder(disk.Config)
 	if err != nil {
 		return "", err
 	}
-	return finder.Find()
+
+	return waitForDevice(ctx, disk.Config.WaitForDevice, disk.Name, func() (string, error) {
+		return dm.pickCandidate(disk, finder, detector)
+	})
+}
+
+func (dm *Manager) pickCandidate(disk *ManagedDisk, finder DiskFinder, detector *InUseDetector) (string, error) {
+	var err error
+	var candidates []string
+	if cf, ok := finder.(CandidateFinder); ok {
+		candidates, err = cf.Candidates()
+	} else {
+		var device string
+		device, err = finder.Find()
+		candidates = []string{device}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var lastErr error
+	for _, device := range candidates {
+		if owner := dm.claimOwner(device); owner != "" {
+			lastErr = fmt.Errorf("device %s is already claimed by disk %s", device, owner)
+		} else if err := detector.Check(device, disk.owned()...); err != nil {
+			lastErr = err
+		} else {
+			dm.claimed[canonicalDeviceName(device)] = disk.Name
+			if ff, ok := finder.(*FileFinder); ok {
+				disk.attachedLoop = ff.Attached
+			}
+			return device, nil
+		}
+		log.Printf("Skipping %s for disk %s: %v", device, disk.Name, lastErr)
+		if ff, ok := finder.(*FileFinder); ok && ff.Attached {
+			if err := DetachLoopDevice(device); err != nil {
+				log.Printf("Warning: Failed to detach rejected %s: %v", device, err)
+			}
+		}
+	}
+
+	if len(candidates) == 1 {
+		return "", lastErr
+	}
+	return "", fmt.Errorf("none of the %d candidate devices is available: %w", len(candidates), lastErr)
+}
+
+// claimOwner returns the disk that has claimed the device, a partition of it,
+// or the whole disk it is a partition of.
+func (dm *Manager) claimOwner(device string) string {
+	name := canonicalDeviceName(device)
+	for claimed, owner := range dm.claimed {
+		if name == claimed || parentDisk(name) == claimed || parentDisk(claimed) == name {
+			return owner
+		}
+	}
+	return ""
+}
+
+// setupPartitions lays out the GPT table of a partitioned disk and sets up
+// each partition. An existing table is verified against the configuration
+// and only rewritten when the format strategy allows it.
+func (dm *Manager) setupPartitions(ctx context.Context, disk *ManagedDisk) error {
+	table, err := ReadPartitionTable(disk.DevicePath)
+	if err != nil {
+		return err
+	}
+
+	fresh := false
+	switch {
+	case table == nil || len(table.Partitions) == 0:
+		if disk.Config.Format == "never" {
+			return fmt.Errorf("disk %s has no partition table but format strategy prevents creating one", disk.Name)
+		}
+		if err := dm.checkUnpartitioned(disk); err != nil {
+			return err
+		}
+		fresh = true
+	case disk.Config.Format == "always":
+		fresh = true
+	default:
+		if err := VerifyPartitionLayout(table, disk.Config.Partitions); err != nil {
+			return fmt.Errorf("partition layout of disk %s does not match configuration: %w", disk.Name, err)
+		}
+		log.Printf("Found expected partition layout on %s", disk.DevicePath)
+	}
+
+	if fresh {
+		if err := CreatePartitionTable(disk.DevicePath, disk.Config.Partitions); err != nil {
+			return fmt.Errorf("failed to partition disk %s: %w", disk.Name, err)
+		}
+		if table, err = ReadPartitionTable(disk.DevicePath); err != nil {
+			return err
+		}
+		if table == nil {
+			return fmt.Errorf("partition table of disk %s not found after partitioning", disk.Name)
+		}
+	}
+
+	for i, part := range disk.Partitions {
+		label := disk.Config.Partitions[i].Label
+		entry, ok := table.Find(label)
+		if !ok {
+			return fmt.Errorf("partition %s not found on %s", label, disk.DevicePath)
+		}
+		part.DevicePath = entry.Node
+
+		log.Printf("Setting up partition %s at device %s", part.Name, part.DevicePath)
+		if err := dm.setupVolume(ctx, part, fresh); err != nil {
+			return err
+		}
+	}
+
+	disk.Initialized = true
+	return nil
+}
+
+// checkUnpartitioned looks at a device without a partition table before it
+// is partitioned. It may still hold a whole-disk LUKS container or
+// filesystem, from an unpartitioned setup of this disk or from another
+// entry, which is only wiped with format 'always' and, for a container
+// initialized for another entry, as the identity policy allows.
+func (dm *Manager) checkUnpartitioned(disk *ManagedDisk) error {
+	isLuks := IsLuksDevice(disk.DevicePath)
+	if isLuks {
+		whole := newManagedDisk(disk.Name, disk.MapperName, disk.Config)
+		whole.Config.Partitions = nil
+		whole.Config.Header = config.HeaderConfig{}
+		whole.DevicePath = disk.DevicePath
+		whole.InitToken = IsInitialized(disk.DevicePath).Token
+		if err := dm.checkIdentity(whole, true); err != nil {
+			return err
+		}
+	}
+	if disk.Config.Format == "always" {
+		return nil
+	}
+
+	content := DetectFilesystem(disk.DevicePath)
+	if content == "" && isLuks {
+		content = "crypto_LUKS"
+	}
+	if content != "" {
+		return fmt.Errorf("disk %s has no partition table but holds %s data; set format to 'always' to partition it", disk.Name, content)
+	}
+	return nil
 }
 
 func (dm *Manager) shouldFormat(disk *ManagedDisk, isLuks bool) bool {
@@ -111,8 +790,9 @@ func (dm *Manager) shouldFormat(disk *ManagedDisk, isLuks bool) bool {
 			// No LUKS means uninitialized, format if encryption is requested
 			return disk.Config.EncryptionKey != ""
 		}
-		// Has LUKS, only format if not initialized
-		return !disk.Initialized
+		// Has LUKS: an incomplete initialization is resumed, and resumeInit
+		// formats containers that the key does not open
+		return false
 	default:
 		return false
 	}
@@ -130,38 +810,61 @@ func (dm *Manager) formatDisk(ctx context.Context, disk *ManagedDisk) error {
 	}
 
 	// Format with LUKS
-	if err := FormatLuks(disk.DevicePath, passphrase); err != nil {
+	if err := FormatLuks(disk.DevicePath, passphrase, disk.luksOptions()); err != nil {
 		return err
 	}
+	disk.Phase = PhaseFormatted
+
+	// Mark the integrity wipe as pending before anything else, so that an
+	// interrupted wipe is resumed rather than mistaken for a complete disk
+	if disk.Config.Integrity != "" {
+		if err := StoreIntegrityState(disk.MetadataDevice(), IntegrityState{State: IntegrityWiping}); err != nil {
+			return err
+		}
+	}
 
-	// Store initialization token
-	if err := StoreInitToken(disk.DevicePath, disk.Name); err != nil {
-		log.Printf("Warning: Failed to store init token: %v", err)
+	// Store initialization token, which tracks the remaining phases
+	if err := dm.writeInitToken(disk); err != nil {
+		return err
 	}
 
 	// Open LUKS device
-	if err := OpenLuks(disk.DevicePath, disk.MapperName, passphrase); err != nil {
+	if err := OpenLuks(disk.DevicePath, disk.MapperName, passphrase, disk.Config.Header.Path); err != nil {
 		return err
 	}
 
+	return dm.initializeOpenedDisk(ctx, disk, 0)
+}
+
+// initializeOpenedDisk wipes the integrity tags if needed, then creates the
+// filesystem on an opened LUKS device and mounts it.
+func (dm *Manager) initializeOpenedDisk(ctx context.Context, disk *ManagedDisk, wipeOffset int64) error {
+	// Initialize integrity tags, unless an interrupted setup already did
+	if disk.Config.Integrity != "" {
+		if state, err := GetIntegrityState(disk.MetadataDevice()); err != nil || state.State != IntegrityComplete {
+			if err := WipeIntegrity(ctx, disk.MetadataDevice(), disk.MapperDevice, wipeOffset); err != nil {
+				CloseLuks(disk.MapperName)
+				return err
+			}
+		}
+	}
+
 	// Create filesystem
-	if err := CreateFilesystem(disk.MapperDevice); err != nil {
+	if err := CreateFilesystem(disk.MapperDevice, disk.Config.Filesystem, disk.Config.Label, disk.Config.MkfsOptions); err != nil {
+		CloseLuks(disk.MapperName)
+		return err
+	}
+	if err := dm.recordFilesystem(disk); err != nil {
 		CloseLuks(disk.MapperName)
 		return err
 	}
 
 	// Mount the device
-	if err := MountDevice(disk.MapperDevice, disk.Config.MountAt); err != nil {
+	if err := MountDevice(disk.MapperDevice, disk.Config.MountAt, disk.Config.Filesystem, disk.Config.MountOptions); err != nil {
 		CloseLuks(disk.MapperName)
 		return fmt.Errorf("failed to mount: %w", err)
 	}
 
-	// Create subdirectories
-	if err := CreateMountDirs(disk.Config.MountAt, []string{"ssh", "data", "logs"}); err != nil {
-		log.Printf("Warning: Failed to create subdirectories: %v", err)
-	}
-
-	disk.Initialized = true
 	log.Printf("Successfully formatted and mounted encrypted disk %s", disk.Name)
 	return nil
 }
@@ -170,22 +873,76 @@ func (dm *Manager) formatPlainDisk(disk *ManagedDisk) error {
 	log.Printf("Formatting plain disk %s", disk.DevicePath)
 	
 	// Create filesystem
-	if err := CreateFilesystem(disk.DevicePath); err != nil {
+	if err := CreateFilesystem(disk.DevicePath, disk.Config.Filesystem, disk.Config.Label, disk.Config.MkfsOptions); err != nil {
+		return err
+	}
+
+	// Mount the device
+	if err := MountDevice(disk.DevicePath, disk.Config.MountAt, disk.Config.Filesystem, disk.Config.MountOptions); err != nil {
+		return err
+	}
+
+	disk.Initialized = true
+	log.Printf("Successfully formatted and mounted plain disk %s", disk.Name)
+	return nil
+}
+
+// setupEphemeralDisk maps the device with plain dm-crypt under a random key
+// that is never stored, then creates and mounts a fresh filesystem. Whatever
+// was written during a previous boot decrypts to noise.
+func (dm *Manager) setupEphemeralDisk(disk *ManagedDisk) error {
+	key, err := GenerateEphemeralKey(disk.Config.Luks.KeySize)
+	if err != nil {
+		return err
+	}
+	err = OpenPlainCrypt(disk.DevicePath, disk.MapperName, key, disk.Config.Luks)
+	wipeKey(key)
+	if err != nil {
+		return err
+	}
+
+	// Create filesystem
+	if err := CreateFilesystem(disk.MapperDevice, disk.Config.Filesystem, disk.Config.Label, disk.Config.MkfsOptions); err != nil {
+		CloseLuks(disk.MapperName)
 		return err
 	}
 
 	// Mount the device
-	if err := MountDevice(disk.DevicePath, disk.Config.MountAt); err != nil {
+	if err := MountDevice(disk.MapperDevice, disk.Config.MountAt, disk.Config.Filesystem, disk.Config.MountOptions); err != nil {
+		CloseLuks(disk.MapperName)
+		return fmt.Errorf("failed to mount: %w", err)
+	}
+
+	disk.Initialized = true
+	log.Printf("Successfully set up ephemeral disk %s", disk.Name)
+	return nil
+}
+
+// setupSwap encrypts the device with an ephemeral key like an ephemeral
+// disk, and activates it as swap instead of mounting a filesystem.
+func (dm *Manager) setupSwap(disk *ManagedDisk) error {
+	key, err := GenerateEphemeralKey(disk.Config.Luks.KeySize)
+	if err != nil {
+		return err
+	}
+	err = OpenPlainCrypt(disk.DevicePath, disk.MapperName, key, disk.Config.Luks)
+	wipeKey(key)
+	if err != nil {
 		return err
 	}
 
-	// Create subdirectories
-	if err := CreateMountDirs(disk.Config.MountAt, []string{"data", "logs"}); err != nil {
-		log.Printf("Warning: Failed to create subdirectories: %v", err)
+	if err := MakeSwap(disk.MapperDevice, disk.Config.Label); err != nil {
+		CloseLuks(disk.MapperName)
+		return err
+	}
+
+	if err := EnableSwap(disk.MapperDevice, disk.Config.SwapPriority); err != nil {
+		CloseLuks(disk.MapperName)
+		return err
 	}
 
 	disk.Initialized = true
-	log.Printf("Successfully formatted and mounted plain disk %s", disk.Name)
+	log.Printf("Successfully enabled encrypted swap on disk %s", disk.Name)
 	return nil
 }
 
@@ -200,31 +957,325 @@ func (dm *Manager) mountExistingDisk(ctx context.Context, disk *ManagedDisk) err
 		return fmt.Errorf("failed to get encryption key: %w", err)
 	}
 
+	if err := dm.verifyLuksParams(disk); err != nil {
+		return err
+	}
+
 	log.Printf("Opening existing LUKS device %s", disk.DevicePath)
 	
 	// Open LUKS device
-	if err := OpenLuks(disk.DevicePath, disk.MapperName, passphrase); err != nil {
+	if err := OpenLuks(disk.DevicePath, disk.MapperName, passphrase, disk.Config.Header.Path); err != nil {
+		return err
+	}
+
+	mountOptions, err := dm.checkFilesystem(disk, disk.MapperDevice)
+	if err != nil {
+		CloseLuks(disk.MapperName)
 		return err
 	}
 
 	// Mount the device
-	if err := MountDevice(disk.MapperDevice, disk.Config.MountAt); err != nil {
+	if err := MountDevice(disk.MapperDevice, disk.Config.MountAt, disk.Config.Filesystem, mountOptions); err != nil {
 		CloseLuks(disk.MapperName)
 		return fmt.Errorf("failed to mount: %w", err)
 	}
 
+	if disk.Config.Integrity != "" {
+		dm.reportIntegrityErrors(disk)
+	}
+
+	dm.growDisk(disk, passphrase)
+
+	err = dm.updateInitToken(disk, func(token *InitToken) {
+		if token.Device != disk.DevicePath {
+			log.Printf("Disk %s is now on %s, recorded as %s", disk.Name, disk.DevicePath, token.Device)
+			token.Device = disk.DevicePath
+		}
+		token.LastOpenedAt = time.Now()
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to record opening of disk %s: %v", disk.Name, err)
+	}
+	dm.backupHeader(disk)
+
 	log.Printf("Successfully mounted existing encrypted disk %s", disk.Name)
 	return nil
 }
 
+// checkFilesystem applies the fsck policy of a disk to its unmounted
+// filesystem and returns the mount options to use. A filesystem with errors
+// is either refused or mounted read-only, as configured.
+func (dm *Manager) checkFilesystem(disk *ManagedDisk, device string) ([]string, error) {
+	options := disk.Config.MountOptions
+	if disk.Config.Fsck == "none" || disk.Config.Fsck == "" {
+		return options, nil
+	}
+
+	log.Printf("Checking %s filesystem of disk %s", disk.Config.Filesystem, disk.Name)
+	result, output, err := CheckFilesystem(device, disk.Config.Filesystem, disk.Config.Fsck == "repair")
+	disk.Fsck = result
+
+	switch result {
+	case FsckClean:
+		log.Printf("Filesystem of disk %s is clean", disk.Name)
+		return options, nil
+	case FsckRepaired:
+		log.Printf("Repaired filesystem of disk %s:\n%s", disk.Name, output)
+		return options, nil
+	case FsckUnavailable:
+		log.Printf("Warning: Cannot check filesystem of disk %s, mounting it unchecked: %v", disk.Name, err)
+		return options, nil
+	}
+
+	if err != nil {
+		log.Printf("Warning: Filesystem check of disk %s failed: %v", disk.Name, err)
+	}
+	if output != "" {
+		log.Printf("Filesystem check of disk %s reported:\n%s", disk.Name, output)
+	}
+
+	if disk.Config.FsckOnError == "read_only" {
+		log.Printf("Warning: Filesystem of disk %s has errors, mounting it read-only", disk.Name)
+		disk.ReadOnly = true
+		return append(append([]string{}, options...), "ro"), nil
+	}
+	return nil, fmt.Errorf("filesystem of disk %s has errors, refusing to mount", disk.Name)
+}
+
+func (dm *Manager) pendingIntegrityWipe(disk *ManagedDisk, isLuks bool) *IntegrityState {
+	if !isLuks || disk.Config.Integrity == "" {
+		return nil
+	}
+	state, err := GetIntegrityState(disk.MetadataDevice())
+	if err != nil || state.State != IntegrityWiping {
+		return nil
+	}
+	return state
+}
+
+func (dm *Manager) resumeIntegrityWipe(ctx context.Context, disk *ManagedDisk, offset int64) error {
+	passphrase, err := dm.keyManager.GetKey(ctx, disk.Config.EncryptionKey)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+
+	log.Printf("Found interrupted integrity wipe on %s", disk.DevicePath)
+	if disk.Phase == PhaseFormatted {
+		if err := dm.writeInitToken(disk); err != nil {
+			return err
+		}
+	}
+	if err := OpenLuks(disk.DevicePath, disk.MapperName, passphrase, disk.Config.Header.Path); err != nil {
+		return err
+	}
+
+	return dm.initializeOpenedDisk(ctx, disk, offset)
+}
+
+// resumeInit continues the initialization of a LUKS container that was
+// interrupted before its filesystem was created. A container without an
+// init token is only taken over if the configured key opens it.
+func (dm *Manager) resumeInit(ctx context.Context, disk *ManagedDisk) error {
+	passphrase, err := dm.keyManager.GetKey(ctx, disk.Config.EncryptionKey)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+
+	log.Printf("Resuming initialization of disk %s after phase %s", disk.Name, disk.Phase)
+	if err := OpenLuks(disk.DevicePath, disk.MapperName, passphrase, disk.Config.Header.Path); err != nil {
+		if disk.Phase == PhaseFormatted && disk.Config.Format == "on_initialize" {
+			log.Printf("LUKS container on %s does not open with the configured key, formatting it", disk.DevicePath)
+			return dm.formatDisk(ctx, disk)
+		}
+		return err
+	}
+
+	if disk.Phase == PhaseFormatted {
+		if err := dm.writeInitToken(disk); err != nil {
+			CloseLuks(disk.MapperName)
+			return err
+		}
+
+		// A filesystem means that the token was lost rather than never
+		// written, and the data is kept
+		if fsType := DetectFilesystem(disk.MapperDevice); fsType != "" {
+			log.Printf("Found %s filesystem on disk %s, keeping it", fsType, disk.Name)
+			err := dm.recordFilesystem(disk)
+			CloseLuks(disk.MapperName)
+			if err != nil {
+				return err
+			}
+			return dm.mountExistingDisk(ctx, disk)
+		}
+	}
+
+	if disk.Config.Format == "never" {
+		CloseLuks(disk.MapperName)
+		return fmt.Errorf("disk %s has no filesystem but format strategy prevents creating one", disk.Name)
+	}
+
+	return dm.initializeOpenedDisk(ctx, disk, 0)
+}
+
+func (dm *Manager) reportIntegrityErrors(disk *ManagedDisk) {
+	errors, err := IntegrityErrors(disk.MapperName)
+	if err != nil {
+		log.Printf("Warning: Unable to check integrity errors of %s: %v", disk.Name, err)
+		return
+	}
+	for _, e := range errors {
+		log.Printf("Warning: Integrity error on disk %s: %s", disk.Name, e)
+	}
+}
+
+// restoreDetachedHeader restores a detached header from its backup when the
+// header itself is missing or damaged. Headers stored on the data disk are
+// never restored automatically, since a missing header there may just mean
+// the finder picked a different disk.
+func (dm *Manager) restoreDetachedHeader(disk *ManagedDisk) {
+	header := disk.Config.Header
+	if header.Backup == "" || IsLuksDevice(header.Path) || !IsLuksDevice(header.Backup) {
+		return
+	}
+
+	log.Printf("Detached header %s of disk %s is missing or damaged, restoring from %s", header.Path, disk.Name, header.Backup)
+	if err := RestoreLuksHeader(header.Path, header.Backup); err != nil {
+		log.Printf("Warning: Failed to restore LUKS header of disk %s: %v", disk.Name, err)
+	}
+}
+
+func (dm *Manager) backupHeader(disk *ManagedDisk) {
+	if disk.Config.Header.Backup == "" {
+		return
+	}
+	if err := BackupLuksHeader(disk.MetadataDevice(), disk.Config.Header.Backup); err != nil {
+		log.Printf("Warning: Failed to back up LUKS header of disk %s: %v", disk.Name, err)
+	}
+}
+
+// verifyLuksParams checks the existing header against the configured LUKS
+// policy. Drift is logged, or refused when on_mismatch is 'error'.
+func (dm *Manager) verifyLuksParams(disk *ManagedDisk) error {
+	meta, err := DumpLuksMetadata(disk.MetadataDevice())
+	if err != nil {
+		if disk.Config.Luks.OnMismatch == "error" {
+			return err
+		}
+		log.Printf("Warning: Unable to verify LUKS parameters of %s: %v", disk.MetadataDevice(), err)
+		return nil
+	}
+
+	drift := CheckLuksParams(meta, disk.luksOptions())
+	if len(drift) == 0 {
+		return nil
+	}
+
+	if disk.Config.Luks.OnMismatch == "error" {
+		return fmt.Errorf("LUKS header of %s does not match policy: %s", disk.MetadataDevice(), strings.Join(drift, "; "))
+	}
+	for _, d := range drift {
+		log.Printf("Warning: LUKS header of %s does not match policy: %s", disk.MetadataDevice(), d)
+	}
+	return nil
+}
+
 func (dm *Manager) mountPlainDisk(disk *ManagedDisk) error {
 	log.Printf("Mounting plain disk %s", disk.DevicePath)
-	
+
+	mountOptions, err := dm.checkFilesystem(disk, disk.DevicePath)
+	if err != nil {
+		return err
+	}
+
 	// Mount the device
-	if err := MountDevice(disk.DevicePath, disk.Config.MountAt); err != nil {
+	if err := MountDevice(disk.DevicePath, disk.Config.MountAt, disk.Config.Filesystem, mountOptions); err != nil {
 		return err
 	}
 
+	dm.growDisk(disk, "")
+
 	log.Printf("Successfully mounted plain disk %s", disk.Name)
 	return nil
-}
\ No newline at end of file
+}
+
+// growDisk extends a mounted disk into space added to its device since it
+// was formatted, when auto_grow is enabled. A failed grow leaves the disk
+// usable at its previous size, so it is only logged.
+func (dm *Manager) growDisk(disk *ManagedDisk, passphrase string) {
+	if !disk.Config.AutoGrow || disk.ReadOnly {
+		return
+	}
+
+	device := disk.DevicePath
+	if disk.Config.EncryptionKey != "" {
+		if err := dm.growLuks(disk, passphrase); err != nil {
+			log.Printf("Warning: Failed to grow LUKS container of disk %s: %v", disk.Name, err)
+			return
+		}
+		device = disk.MapperDevice
+	}
+
+	available, err := deviceSize(device)
+	if err != nil {
+		log.Printf("Warning: Unable to check disk %s for growth: %v", disk.Name, err)
+		return
+	}
+	used, err := FilesystemSize(device, disk.Config.MountAt, disk.Config.Filesystem)
+	if err != nil {
+		log.Printf("Warning: Unable to check disk %s for growth: %v", disk.Name, err)
+		return
+	}
+	if available-used < growThreshold {
+		return
+	}
+
+	log.Printf("Disk %s has %d bytes beyond its filesystem, growing", disk.Name, available-used)
+	if err := GrowFilesystem(device, disk.Config.MountAt, disk.Config.Filesystem); err != nil {
+		log.Printf("Warning: Failed to grow filesystem of disk %s: %v", disk.Name, err)
+		return
+	}
+
+	grown, err := FilesystemSize(device, disk.Config.MountAt, disk.Config.Filesystem)
+	if err != nil {
+		grown = available
+	}
+	log.Printf("Grew filesystem of disk %s from %d to %d bytes", disk.Name, used, grown)
+
+	if disk.Config.EncryptionKey != "" {
+		err := dm.updateInitToken(disk, func(token *InitToken) {
+			token.FilesystemSize = grown
+			token.GrownFrom = used
+			token.GrownAt = time.Now()
+		})
+		if err != nil {
+			log.Printf("Warning: Failed to record growth of disk %s: %v", disk.Name, err)
+		}
+	}
+}
+
+// growLuks resizes the LUKS mapping of a disk when its device is larger than
+// the payload the mapping covers.
+func (dm *Manager) growLuks(disk *ManagedDisk, passphrase string) error {
+	meta, err := DumpLuksMetadata(disk.MetadataDevice())
+	if err != nil {
+		return err
+	}
+	offset, err := LuksPayloadOffset(meta)
+	if err != nil {
+		return err
+	}
+	total, err := deviceSize(disk.DevicePath)
+	if err != nil {
+		return err
+	}
+	payload, err := deviceSize(disk.MapperDevice)
+	if err != nil {
+		return err
+	}
+	if total-offset <= payload {
+		return nil
+	}
+
+	log.Printf("Device %s of disk %s has grown, resizing LUKS mapping from %d to %d bytes", disk.DevicePath, disk.Name, payload, total-offset)
+	return ResizeLuks(disk.MapperName, passphrase, disk.Config.Header.Path)
+}
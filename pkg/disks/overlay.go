@@ -0,0 +1,53 @@
+package disks
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"tdx-init/pkg/config"
+)
+
+// SetupOverlay makes a directory of the root filesystem writable by mounting
+// overlayfs over it, with the upper and work directories on a managed disk.
+// The disk must already be mounted.
+func (dm *Manager) SetupOverlay(overlay config.OverlayConfig) error {
+	disk, ok := dm.disks[overlay.Disk]
+	if !ok {
+		return fmt.Errorf("disk %s not found", overlay.Disk)
+	}
+	if !IsMounted(disk.Config.MountAt) {
+		return fmt.Errorf("disk %s is not mounted", overlay.Disk)
+	}
+
+	dir := filepath.Join(disk.Config.MountAt, overlay.Dir)
+	upper := filepath.Join(dir, "upper")
+	work := filepath.Join(dir, "work")
+
+	if overlay.Reset && !IsMounted(overlay.Target) {
+		log.Printf("Discarding changes to %s", overlay.Target)
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to reset overlay %s: %w", overlay.Target, err)
+		}
+	}
+
+	for _, d := range []string{upper, work} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", d, err)
+		}
+	}
+
+	if err := MountOverlay(overlay.Target, upper, work, overlay.Target); err != nil {
+		return err
+	}
+
+	log.Printf("Mounted overlay on %s with changes kept on disk %s", overlay.Target, overlay.Disk)
+	return nil
+}
+
+func (dm *Manager) TeardownOverlay(overlay config.OverlayConfig) error {
+	if err := UnmountDevice(overlay.Target); err != nil {
+		return fmt.Errorf("failed to unmount overlay %s: %w", overlay.Target, err)
+	}
+	return nil
+}
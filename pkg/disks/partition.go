@@ -0,0 +1,160 @@
+package disks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"tdx-init/pkg/config"
+)
+
+const partitionAlignment = 1 << 20
+
+type PartitionTable struct {
+	Label      string      `json:"label"`
+	Device     string      `json:"device"`
+	SectorSize int64       `json:"sectorsize"`
+	Partitions []Partition `json:"partitions"`
+}
+
+type Partition struct {
+	Node  string `json:"node"`
+	Start int64  `json:"start"`
+	Size  int64  `json:"size"`
+	Name  string `json:"name"`
+	UUID  string `json:"uuid"`
+}
+
+// ReadPartitionTable returns the partition table of a device, or nil when
+// the device does not carry one.
+func ReadPartitionTable(device string) (*PartitionTable, error) {
+	cmd := exec.Command("sfdisk", "--json", device)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "does not contain a recognized partition table") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read partition table: %w (%s)", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var result struct {
+		PartitionTable PartitionTable `json:"partitiontable"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse partition table: %w", err)
+	}
+
+	table := &result.PartitionTable
+	if table.SectorSize == 0 {
+		table.SectorSize = 512
+	}
+	return table, nil
+}
+
+// Find returns the partition with the given GPT partition label.
+func (t *PartitionTable) Find(label string) (Partition, bool) {
+	for _, part := range t.Partitions {
+		if part.Name == label {
+			return part, true
+		}
+	}
+	return Partition{}, false
+}
+
+// CreatePartitionTable writes a new GPT table with one named partition per
+// entry, wiping any previous table and signatures on the device.
+func CreatePartitionTable(device string, parts []config.PartitionConfig) error {
+	sizes, err := partitionSizes(device, parts)
+	if err != nil {
+		return err
+	}
+
+	var script strings.Builder
+	script.WriteString("label: gpt\n")
+	for i, part := range parts {
+		if sizes[i] > 0 {
+			fmt.Fprintf(&script, "size=%dKiB, name=%s\n", sizes[i]/1024, strconv.Quote(part.Label))
+		} else {
+			fmt.Fprintf(&script, "name=%s\n", strconv.Quote(part.Label))
+		}
+	}
+
+	log.Printf("Creating GPT partition table on %s", device)
+	cmd := exec.Command("sfdisk", "--wipe", "always", "--wipe-partitions", "always", device)
+	cmd.Stdin = strings.NewReader(script.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create partition table: %w (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+
+	// Wait for udev to create the partition device nodes
+	exec.Command("udevadm", "settle").Run()
+
+	return nil
+}
+
+// VerifyPartitionLayout checks that an existing table has the configured
+// partitions, in order, with the configured fixed sizes. Percentage sizes
+// are not compared since they depend on the disk size at creation time.
+func VerifyPartitionLayout(table *PartitionTable, parts []config.PartitionConfig) error {
+	if table.Label != "gpt" {
+		return fmt.Errorf("expected a GPT partition table, found %s", table.Label)
+	}
+	if len(table.Partitions) != len(parts) {
+		return fmt.Errorf("expected %d partitions, found %d", len(parts), len(table.Partitions))
+	}
+
+	for i, part := range parts {
+		existing := table.Partitions[i]
+		if existing.Name != part.Label {
+			return fmt.Errorf("expected partition %d to be labeled %s, found %s", i+1, part.Label, existing.Name)
+		}
+
+		size, err := config.ParsePartitionSize(part.Size)
+		if err != nil {
+			return err
+		}
+		if size.Bytes > 0 && existing.Size*table.SectorSize != size.Bytes/1024*1024 {
+			return fmt.Errorf("partition %s has %d bytes, expected %d", part.Label, existing.Size*table.SectorSize, size.Bytes)
+		}
+	}
+
+	return nil
+}
+
+// partitionSizes resolves configured sizes to bytes. Percentages are taken
+// of the usable space (the disk minus room for the GPT headers) and rounded
+// down to the partition alignment; zero means the remaining space.
+func partitionSizes(device string, parts []config.PartitionConfig) ([]int64, error) {
+	total, err := deviceSize(device)
+	if err != nil {
+		return nil, err
+	}
+	usable := total - 2*partitionAlignment
+
+	sizes := make([]int64, len(parts))
+	var sum int64
+	for i, part := range parts {
+		size, err := config.ParsePartitionSize(part.Size)
+		if err != nil {
+			return nil, fmt.Errorf("partition %s: %w", part.Label, err)
+		}
+		switch {
+		case size.Bytes > 0:
+			sizes[i] = size.Bytes
+		case size.Percent > 0:
+			sizes[i] = usable * int64(size.Percent) / 100 / partitionAlignment * partitionAlignment
+		}
+		sum += sizes[i]
+	}
+
+	if sum > usable {
+		return nil, fmt.Errorf("partitions need %d bytes but %s only has %d usable", sum, device, usable)
+	}
+
+	return sizes, nil
+}
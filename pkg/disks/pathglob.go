@@ -3,7 +3,6 @@ package disks
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 )
@@ -19,40 +18,39 @@ func NewPathGlobFinder(pattern string) *PathGlobFinder {
 }
 
 func (f *PathGlobFinder) Find() (string, error) {
+	candidates, err := f.Candidates()
+	if err != nil {
+		return "", err
+	}
+	return firstUnused(candidates)
+}
+
+func (f *PathGlobFinder) Candidates() ([]string, error) {
 	matches, err := filepath.Glob(f.Pattern)
 	if err != nil {
-		return "", fmt.Errorf("invalid glob pattern: %w", err)
+		return nil, fmt.Errorf("invalid glob pattern: %w", err)
 	}
 
+	var candidates []string
+
 	for _, path := range matches {
 		if !strings.HasPrefix(path, "/dev/") {
 			continue
 		}
 
-		if f.isBootDevice(path) {
-			continue
-		}
-
 		info, err := os.Stat(path)
 		if err != nil {
 			continue
 		}
 
 		if info.Mode()&os.ModeCharDevice == 0 {
-			return path, nil
+			candidates = append(candidates, path)
 		}
 	}
 
-	return "", fmt.Errorf("no disk found matching pattern %s", f.Pattern)
-}
-
-func (f *PathGlobFinder) isBootDevice(device string) bool {
-	cmd := exec.Command("findmnt", "-n", "-o", "SOURCE", "/")
-	output, err := cmd.Output()
-	if err != nil {
-		return false
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no disk found matching pattern %s", f.Pattern)
 	}
 
-	bootDevice := strings.TrimSpace(string(output))
-	return strings.HasPrefix(bootDevice, device)
-}
\ No newline at end of file
+	return candidates, nil
+}
@@ -0,0 +1,66 @@
+package disks
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"tdx-init/pkg/config"
+)
+
+// PersistDirectory bind-mounts a directory of a mounted disk over a path of
+// the root filesystem. When the source does not exist yet, which is the case
+// on first boot, it is seeded with whatever the image ships at the target,
+// so that packages that install files there keep working.
+func PersistDirectory(mountPoint string, entry config.PersistConfig) error {
+	source := filepath.Join(mountPoint, entry.Source)
+
+	if _, err := os.Stat(source); os.IsNotExist(err) {
+		if err := seedDirectory(source, entry.Target); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", source, err)
+	}
+
+	return BindMount(source, entry.Target)
+}
+
+// seedDirectory copies the target into a temporary directory next to the
+// source and renames it into place, so that an interrupted copy is redone on
+// the next boot instead of being mistaken for a complete one.
+func seedDirectory(source, target string) error {
+	tmp := source + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("failed to remove stale %s: %w", tmp, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(source), 0700); err != nil {
+		return fmt.Errorf("failed to create parent of %s: %w", source, err)
+	}
+
+	info, err := os.Stat(target)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.Mkdir(tmp, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", tmp, err)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to stat %s: %w", target, err)
+	case !info.IsDir():
+		return fmt.Errorf("%s is not a directory", target)
+	default:
+		log.Printf("Copying existing contents of %s to %s", target, source)
+		output, err := exec.Command("cp", "-a", "--", target, tmp).CombinedOutput()
+		if err != nil {
+			os.RemoveAll(tmp)
+			return fmt.Errorf("failed to copy %s: %w (output: %s)", target, err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	if err := os.Rename(tmp, source); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", source, err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package disks
+
+// Initialization phases of an encrypted disk, in order. Each phase is
+// recorded in the init token once the step it names has completed, so that
+// an interrupted setup is resumed on the next boot instead of reformatting
+// the disk or mounting a device without a filesystem.
+const (
+	// PhaseFormatted is never stored: it is reported for a LUKS device that
+	// has no init token, because formatting was interrupted before the token
+	// was written or the device was not set up by tdx-init.
+	PhaseFormatted         = "formatted"
+	PhaseTokenWritten      = "token-written"
+	PhaseFilesystemCreated = "filesystem-created"
+	PhaseLayoutApplied     = "layout-applied"
+	PhaseComplete          = "complete"
+)
+
+var phaseOrder = []string{
+	PhaseFormatted,
+	PhaseTokenWritten,
+	PhaseFilesystemCreated,
+	PhaseLayoutApplied,
+	PhaseComplete,
+}
+
+// phaseReached reports whether phase is at or past target.
+func phaseReached(phase, target string) bool {
+	return phaseIndex(phase) >= phaseIndex(target)
+}
+
+// phaseIndex places phases it does not know, such as those written by a
+// newer version, after complete, so they are never taken as a reason to
+// create a filesystem.
+func phaseIndex(phase string) int {
+	for i, p := range phaseOrder {
+		if p == phase {
+			return i
+		}
+	}
+	return len(phaseOrder)
+}
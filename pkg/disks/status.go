@@ -0,0 +1,63 @@
+package disks
+
+import "sort"
+
+// DiskStatus is the state of one disk or partition after setup, as shown by
+// the status command.
+type DiskStatus struct {
+	Name        string `json:"name"`
+	Device      string `json:"device,omitempty"`
+	Mapper      string `json:"mapper,omitempty"`
+	MountPoint  string `json:"mount_point,omitempty"`
+	Mounted     bool   `json:"mounted"`
+	Initialized bool   `json:"initialized"`
+	Phase       string `json:"phase,omitempty"`
+	Fsck        string `json:"fsck,omitempty"`
+	ReadOnly    bool   `json:"read_only,omitempty"`
+
+	LuksUUID       string   `json:"luks_uuid,omitempty"`
+	HeaderWarnings []string `json:"header_warnings,omitempty"`
+}
+
+// Status reports every managed disk and partition, sorted by name.
+func (dm *Manager) Status() []DiskStatus {
+	var statuses []DiskStatus
+	for _, disk := range dm.disks {
+		statuses = append(statuses, disk.status())
+		for _, part := range disk.Partitions {
+			statuses = append(statuses, part.status())
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+func (md *ManagedDisk) status() DiskStatus {
+	status := DiskStatus{
+		Name:        md.Name,
+		Device:      md.DevicePath,
+		MountPoint:  md.Config.MountAt,
+		Initialized: md.Initialized,
+		Phase:       md.Phase,
+		Fsck:        md.Fsck,
+		ReadOnly:    md.ReadOnly,
+	}
+	if md.Config.EncryptionKey != "" || md.Config.Format == "ephemeral" {
+		status.Mapper = md.MapperName
+	}
+	if md.Config.MountAt != "" {
+		status.Mounted = IsMounted(md.Config.MountAt)
+	}
+	if md.Config.EncryptionKey != "" && md.DevicePath != "" {
+		// A damaged header copy does not stop the disk from opening, so
+		// this is the only place it shows up
+		hdr, err := ReadLuks2Header(md.MetadataDevice())
+		if err != nil {
+			status.HeaderWarnings = []string{err.Error()}
+		} else {
+			status.LuksUUID = hdr.UUID
+			status.HeaderWarnings = hdr.Warnings
+		}
+	}
+	return status
+}
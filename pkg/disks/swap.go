@@ -0,0 +1,81 @@
+package disks
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func MakeSwap(device, label string) error {
+	args := []string{}
+	if label != "" {
+		args = append(args, "-L", label)
+	}
+	args = append(args, device)
+
+	log.Printf("Creating swap area on %s", device)
+	output, err := exec.Command("mkswap", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to create swap area: %w (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// EnableSwap activates a swap area. A nil priority leaves the choice to the
+// kernel.
+func EnableSwap(device string, priority *int) error {
+	args := []string{}
+	if priority != nil {
+		args = append(args, "--priority", fmt.Sprint(*priority))
+	}
+	args = append(args, device)
+
+	output, err := exec.Command("swapon", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to enable swap: %w (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func DisableSwap(device string) error {
+	if !IsSwapActive(device) {
+		return nil
+	}
+	output, err := exec.Command("swapoff", device).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to disable swap: %w (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// IsSwapActive reports whether the device is listed in /proc/swaps, which
+// shows device-mapper devices by their /dev/dm-N node.
+func IsSwapActive(device string) bool {
+	target, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return false
+	}
+
+	file, err := os.Open("/proc/swaps")
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		swap := unescapeMountField(fields[0])
+		if swap == device || swap == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package disks
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type SysfsFinder struct {
+	Transport  string
+	Model      string
+	Vendor     string
+	Rotational *bool
+	Removable  *bool
+	ReadOnly   bool
+	MinSize    int64
+	MaxSize    int64
+	Select     string
+}
+
+type sysfsDisk struct {
+	Name       string
+	Transport  string
+	Model      string
+	Vendor     string
+	Rotational bool
+	Removable  bool
+	ReadOnly   bool
+	Size       int64
+}
+
+func NewSysfsFinder() *SysfsFinder {
+	return &SysfsFinder{
+		Select: "largest",
+	}
+}
+
+func (f *SysfsFinder) Find() (string, error) {
+	candidates, err := f.Candidates()
+	if err != nil {
+		return "", err
+	}
+	return firstUnused(candidates)
+}
+
+func (f *SysfsFinder) Candidates() ([]string, error) {
+	names, err := listBlockDevices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block devices: %w", err)
+	}
+
+	var disks []sysfsDisk
+	for _, name := range names {
+		disk, ok := readSysfsDisk(name)
+		if !ok {
+			continue
+		}
+		if f.matches(disk) {
+			disks = append(disks, disk)
+		}
+	}
+
+	switch f.Select {
+	case "largest":
+		sort.SliceStable(disks, func(i, j int) bool { return disks[i].Size > disks[j].Size })
+	case "smallest":
+		sort.SliceStable(disks, func(i, j int) bool { return disks[i].Size < disks[j].Size })
+	case "first":
+	default:
+		return nil, fmt.Errorf("unknown selection rule: %s", f.Select)
+	}
+
+	if len(disks) == 0 {
+		return nil, fmt.Errorf("no disk found matching sysfs filters")
+	}
+
+	var paths []string
+	for _, disk := range disks {
+		paths = append(paths, "/dev/"+disk.Name)
+	}
+	return paths, nil
+}
+
+func (f *SysfsFinder) matches(disk sysfsDisk) bool {
+	if f.Transport != "" && !strings.EqualFold(f.Transport, disk.Transport) {
+		return false
+	}
+	if f.Model != "" && !matchFold(f.Model, disk.Model) {
+		return false
+	}
+	if f.Vendor != "" && !matchFold(f.Vendor, disk.Vendor) {
+		return false
+	}
+	if f.Rotational != nil && *f.Rotational != disk.Rotational {
+		return false
+	}
+	if f.Removable != nil && *f.Removable != disk.Removable {
+		return false
+	}
+	if f.ReadOnly != disk.ReadOnly {
+		return false
+	}
+	if f.MinSize > 0 && disk.Size < f.MinSize {
+		return false
+	}
+	if f.MaxSize > 0 && disk.Size > f.MaxSize {
+		return false
+	}
+	return true
+}
+
+// readSysfsDisk returns the attributes of a whole, physically backed disk.
+// Partitions and virtual devices such as loop, dm or zram have no device
+// link in sysfs and are skipped.
+func readSysfsDisk(name string) (sysfsDisk, bool) {
+	if isPartition(name) || !sysfsExists(name, "device") {
+		return sysfsDisk{}, false
+	}
+
+	sectors, err := strconv.ParseInt(readSysfsAttr(name, "size"), 10, 64)
+	if err != nil || sectors == 0 {
+		return sysfsDisk{}, false
+	}
+
+	return sysfsDisk{
+		Name:       name,
+		Transport:  blockTransport(name),
+		Model:      readSysfsAttr(name, "device/model"),
+		Vendor:     readSysfsAttr(name, "device/vendor"),
+		Rotational: readSysfsAttr(name, "queue/rotational") == "1",
+		Removable:  readSysfsAttr(name, "removable") == "1",
+		ReadOnly:   readSysfsAttr(name, "ro") == "1",
+		Size:       sectors * 512,
+	}, true
+}
+
+func blockTransport(name string) string {
+	path, err := filepath.EvalSymlinks(filepath.Join(sysClassBlock, name))
+	if err != nil {
+		return ""
+	}
+
+	switch {
+	case strings.Contains(path, "/usb"):
+		return "usb"
+	case strings.Contains(path, "/nvme"):
+		return "nvme"
+	case strings.Contains(path, "/mmc"):
+		return "mmc"
+	case strings.Contains(path, "/ata"):
+		return "sata"
+	case strings.Contains(path, "/target"):
+		return "scsi"
+	case strings.Contains(path, "/virtio"):
+		return "virtio"
+	case strings.Contains(path, "/xen") || strings.Contains(path, "/vbd-"):
+		return "xen"
+	default:
+		return ""
+	}
+}
+
+func matchFold(pattern, value string) bool {
+	matched, err := filepath.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return err == nil && matched
+}
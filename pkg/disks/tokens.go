@@ -0,0 +1,244 @@
+package disks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LUKS2 headers hold at most this many tokens.
+const maxLuksTokens = 32
+
+// Token types written by tdx-init.
+const (
+	InitTokenType      = "tdx-init"
+	SSHTokenType       = "ssh-key"
+	IntegrityTokenType = "tdx-init-integrity"
+)
+
+// reservedTokenIDs keeps the token IDs these types had before IDs were
+// allocated, where older versions of tdx-init look for them. Other types
+// are not given these IDs.
+var reservedTokenIDs = map[string]int{
+	InitTokenType:      1,
+	SSHTokenType:       2,
+	IntegrityTokenType: 3,
+}
+
+var ErrTokenNotFound = errors.New("token not found")
+
+// TokenStore keeps small JSON payloads in the LUKS2 tokens of a device or
+// detached header, one token per type name. A token ID is allocated when a
+// type is first stored. Tokens of other programs, such as those enrolled by
+// systemd-cryptenroll, are left alone.
+type TokenStore struct {
+	device string
+}
+
+// TokenInfo describes a token in the header. Size is the length of its
+// JSON in the metadata area.
+type TokenInfo struct {
+	ID   int
+	Type string
+	Size int
+}
+
+type luksToken struct {
+	Type     string          `json:"type"`
+	Keyslots []string        `json:"keyslots"`
+	UserData json.RawMessage `json:"user_data,omitempty"`
+}
+
+// tokenArea is the token part of the LUKS2 JSON metadata, with the space
+// used by the whole metadata and the size of the area that holds it.
+type tokenArea struct {
+	tokens map[int]luksToken
+	sizes  map[int]int
+	used   int
+	limit  int
+}
+
+func NewTokenStore(devicePath string) *TokenStore {
+	return &TokenStore{device: devicePath}
+}
+
+// Get decodes the payload of the token of the given type into v.
+func (s *TokenStore) Get(tokenType string, v interface{}) error {
+	area, err := s.read()
+	if err != nil {
+		return err
+	}
+	id, ok := area.find(tokenType)
+	if !ok {
+		return fmt.Errorf("%s token: %w", tokenType, ErrTokenNotFound)
+	}
+	if err := json.Unmarshal(area.tokens[id].UserData, v); err != nil {
+		return fmt.Errorf("failed to parse %s token: %w", tokenType, err)
+	}
+	return nil
+}
+
+// Put stores v as the payload of the token of the given type. An existing
+// token is replaced in a single header update, so it is never lost or half
+// written if the write is interrupted.
+func (s *TokenStore) Put(tokenType string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s token: %w", tokenType, err)
+	}
+	tokenJSON, err := json.Marshal(luksToken{Type: tokenType, Keyslots: []string{}, UserData: payload})
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s token: %w", tokenType, err)
+	}
+
+	area, err := s.read()
+	if err != nil {
+		return err
+	}
+	id, err := area.place(tokenType, tokenJSON)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.device, err)
+	}
+
+	cmd := exec.Command("cryptsetup", "token", "import", "--token-id", strconv.Itoa(id), "--token-replace", s.device)
+	cmd.Stdin = bytes.NewReader(tokenJSON)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to store %s token: %w (output: %s)", tokenType, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Delete removes the token of the given type, if there is one.
+func (s *TokenStore) Delete(tokenType string) error {
+	area, err := s.read()
+	if err != nil {
+		return err
+	}
+	id, ok := area.find(tokenType)
+	if !ok {
+		return nil
+	}
+
+	cmd := exec.Command("cryptsetup", "token", "remove", "--token-id", strconv.Itoa(id), s.device)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove %s token: %w (output: %s)", tokenType, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// List describes every token in the header, including those of other
+// programs, ordered by ID.
+func (s *TokenStore) List() ([]TokenInfo, error) {
+	area, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]TokenInfo, 0, len(area.tokens))
+	for id, token := range area.tokens {
+		infos = append(infos, TokenInfo{ID: id, Type: token.Type, Size: area.sizes[id]})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos, nil
+}
+
+func (s *TokenStore) read() (*tokenArea, error) {
+	hdr, err := ReadLuks2Header(s.device)
+	if err != nil {
+		return nil, err
+	}
+
+	// cryptsetup writes the metadata as compact JSON, so sizes measured
+	// after compacting match the space taken in the header
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, hdr.json); err != nil {
+		return nil, fmt.Errorf("failed to parse LUKS metadata: %w", err)
+	}
+
+	area := &tokenArea{
+		tokens: make(map[int]luksToken),
+		sizes:  make(map[int]int),
+		used:   compact.Len(),
+		limit:  int(hdr.HeaderSize) - luks2BinaryHeaderSize,
+	}
+
+	for key, raw := range hdr.Metadata.Tokens {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+		var token luksToken
+		if err := json.Unmarshal(raw, &token); err != nil {
+			continue
+		}
+		compact.Reset()
+		json.Compact(&compact, raw)
+		area.tokens[id] = token
+		area.sizes[id] = compact.Len()
+	}
+
+	return area, nil
+}
+
+// find returns the ID of the token of the given type, preferring the
+// reserved ID if several tokens share the type.
+func (a *tokenArea) find(tokenType string) (int, bool) {
+	if id, ok := reservedTokenIDs[tokenType]; ok && a.tokens[id].Type == tokenType {
+		return id, true
+	}
+	for id := 0; id < maxLuksTokens; id++ {
+		if token, ok := a.tokens[id]; ok && token.Type == tokenType {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// place returns the ID a token of the given type and JSON is stored under:
+// that of the existing token of the type, which it replaces, or a newly
+// allocated one. It fails if the metadata would no longer fit in its area.
+func (a *tokenArea) place(tokenType string, tokenJSON []byte) (int, error) {
+	id, exists := a.find(tokenType)
+	needed := a.used + len(tokenJSON)
+	if exists {
+		needed -= a.sizes[id]
+	} else {
+		var err error
+		if id, err = a.allocate(tokenType); err != nil {
+			return 0, err
+		}
+		// The new entry also takes a key and a separator
+		needed += len(fmt.Sprintf(`,"%d":`, id))
+	}
+	// The metadata is stored NUL-terminated
+	if a.limit > 0 && needed >= a.limit {
+		return 0, fmt.Errorf("%s token of %d bytes does not fit in the LUKS2 metadata area (%d of %d bytes used)", tokenType, len(tokenJSON), a.used, a.limit)
+	}
+	return id, nil
+}
+
+// allocate picks the ID for a new token: the reserved ID of its type if that
+// is free, or else the lowest free ID not reserved for another type.
+func (a *tokenArea) allocate(tokenType string) (int, error) {
+	if id, ok := reservedTokenIDs[tokenType]; ok {
+		if _, taken := a.tokens[id]; !taken {
+			return id, nil
+		}
+	}
+
+	reserved := make(map[int]bool)
+	for _, id := range reservedTokenIDs {
+		reserved[id] = true
+	}
+	for id := 0; id < maxLuksTokens; id++ {
+		if _, taken := a.tokens[id]; !taken && !reserved[id] {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no free LUKS2 token slot for %s token", tokenType)
+}
@@ -0,0 +1,323 @@
+package disks
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testTokenArea builds a token area holding tokens of the given types,
+// keyed by ID.
+func testTokenArea(types map[int]string) *tokenArea {
+	area := &tokenArea{
+		tokens: make(map[int]luksToken),
+		sizes:  make(map[int]int),
+	}
+	for id, tokenType := range types {
+		area.tokens[id] = luksToken{Type: tokenType}
+	}
+	return area
+}
+
+func TestTokenAreaAllocate(t *testing.T) {
+	full := make(map[int]string)
+	for id := 0; id < maxLuksTokens; id++ {
+		full[id] = "other"
+	}
+	unreserved := make(map[int]string)
+	for id := 0; id < maxLuksTokens; id++ {
+		if id < 1 || id > 3 {
+			unreserved[id] = "other"
+		}
+	}
+
+	tests := []struct {
+		name      string
+		tokens    map[int]string
+		tokenType string
+		want      int
+		wantErr   bool
+	}{
+		{name: "init token", tokenType: InitTokenType, want: 1},
+		{name: "ssh token", tokenType: SSHTokenType, want: 2},
+		{name: "integrity token", tokenType: IntegrityTokenType, want: 3},
+		{name: "other type takes lowest free", tokenType: "custom", want: 0},
+		{
+			name:      "other type skips reserved",
+			tokens:    map[int]string{0: "systemd-tpm2"},
+			tokenType: "custom",
+			want:      4,
+		},
+		{
+			name:      "other type skips used",
+			tokens:    map[int]string{0: "systemd-tpm2", 4: "systemd-fido2", 5: "custom-a"},
+			tokenType: "custom",
+			want:      6,
+		},
+		{
+			name:      "reserved ID taken by another program",
+			tokens:    map[int]string{1: "systemd-tpm2"},
+			tokenType: InitTokenType,
+			want:      0,
+		},
+		{
+			name:      "reserved ID taken and no other free",
+			tokens:    unreserved,
+			tokenType: "custom",
+			wantErr:   true,
+		},
+		{
+			name:      "reserved ID still free",
+			tokens:    unreserved,
+			tokenType: SSHTokenType,
+			want:      2,
+		},
+		{
+			name:      "header full",
+			tokens:    full,
+			tokenType: InitTokenType,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testTokenArea(tt.tokens).allocate(tt.tokenType)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("allocate() = %d, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("allocate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("allocate() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenAreaFind(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokens    map[int]string
+		tokenType string
+		want      int
+		wantOK    bool
+	}{
+		{name: "empty", tokenType: InitTokenType},
+		{
+			name:      "at reserved ID",
+			tokens:    map[int]string{0: "systemd-tpm2", 1: InitTokenType},
+			tokenType: InitTokenType,
+			want:      1,
+			wantOK:    true,
+		},
+		{
+			name:      "elsewhere",
+			tokens:    map[int]string{1: "systemd-tpm2", 7: InitTokenType},
+			tokenType: InitTokenType,
+			want:      7,
+			wantOK:    true,
+		},
+		{
+			name:      "reserved ID preferred",
+			tokens:    map[int]string{0: SSHTokenType, 2: SSHTokenType},
+			tokenType: SSHTokenType,
+			want:      2,
+			wantOK:    true,
+		},
+		{
+			name:      "lowest ID otherwise",
+			tokens:    map[int]string{9: "custom", 4: "custom"},
+			tokenType: "custom",
+			want:      4,
+			wantOK:    true,
+		},
+		{
+			name:      "other types only",
+			tokens:    map[int]string{1: "systemd-tpm2", 2: IntegrityTokenType},
+			tokenType: SSHTokenType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := testTokenArea(tt.tokens).find(tt.tokenType)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("find() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTokenAreaPlace(t *testing.T) {
+	const limit = 1000
+	tokenJSON := func(size int) []byte {
+		return []byte(strings.Repeat("x", size))
+	}
+	// A new token at ID 1 also takes `,"1":`
+	const keySize = 5
+
+	tests := []struct {
+		name      string
+		tokens    map[int]string
+		sizes     map[int]int
+		used      int
+		limit     int
+		tokenType string
+		size      int
+		want      int
+		wantErr   bool
+	}{
+		{
+			name:      "new token",
+			used:      400,
+			limit:     limit,
+			tokenType: InitTokenType,
+			size:      100,
+			want:      1,
+		},
+		{
+			name:      "new token just fits",
+			used:      400,
+			limit:     limit,
+			tokenType: InitTokenType,
+			size:      limit - 400 - keySize - 1,
+			want:      1,
+		},
+		{
+			name:      "new token one byte over",
+			used:      400,
+			limit:     limit,
+			tokenType: InitTokenType,
+			size:      limit - 400 - keySize,
+			wantErr:   true,
+		},
+		{
+			name:      "replacing frees the old token",
+			tokens:    map[int]string{1: InitTokenType},
+			sizes:     map[int]int{1: 300},
+			used:      900,
+			limit:     limit,
+			tokenType: InitTokenType,
+			size:      350,
+			want:      1,
+		},
+		{
+			name:      "replacement too large",
+			tokens:    map[int]string{1: InitTokenType},
+			sizes:     map[int]int{1: 300},
+			used:      900,
+			limit:     limit,
+			tokenType: InitTokenType,
+			size:      400,
+			wantErr:   true,
+		},
+		{
+			name:      "replaced at its own ID",
+			tokens:    map[int]string{1: "systemd-tpm2", 5: InitTokenType},
+			sizes:     map[int]int{1: 100, 5: 100},
+			used:      500,
+			limit:     limit,
+			tokenType: InitTokenType,
+			size:      100,
+			want:      5,
+		},
+		{
+			name:      "unknown limit",
+			used:      400,
+			tokenType: InitTokenType,
+			size:      100000,
+			want:      1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			area := testTokenArea(tt.tokens)
+			for id, size := range tt.sizes {
+				area.sizes[id] = size
+			}
+			area.used = tt.used
+			area.limit = tt.limit
+
+			got, err := area.place(tt.tokenType, tokenJSON(tt.size))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("place() = %d, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("place() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("place() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenStoreRead(t *testing.T) {
+	const hdrSize = 0x4000
+	metadata := `{"keyslots":{},"tokens":{` +
+		`"0":{"type":"systemd-tpm2","keyslots":["0"]},` +
+		`"1":{"type":"tdx-init","keyslots":[],"user_data":{"version":"2","phase":"complete"}},` +
+		`"2":{"type":"ssh-key","keyslots":[],"user_data":{"ssh_key":"ssh-ed25519 AAAA"}}` +
+		`},"segments":{},"digests":{},"config":{"json_size":"12288","keyslots_size":"16384"}}`
+
+	img := make([]byte, 0x10000)
+	writeLuks2Copy(img, 0, hdrSize, luks2MagicPrimary, 1, "sha256", metadata)
+	writeLuks2Copy(img, hdrSize, hdrSize, luks2MagicSecondary, 1, "sha256", metadata)
+	path := filepath.Join(t.TempDir(), "header.img")
+	if err := os.WriteFile(path, img, 0600); err != nil {
+		t.Fatal(err)
+	}
+	store := NewTokenStore(path)
+
+	area, err := store.read()
+	if err != nil {
+		t.Fatalf("read() error = %v", err)
+	}
+	if area.limit != hdrSize-luks2BinaryHeaderSize {
+		t.Errorf("limit = %d, want %d", area.limit, hdrSize-luks2BinaryHeaderSize)
+	}
+	if area.used != len(metadata) {
+		t.Errorf("used = %d, want %d", area.used, len(metadata))
+	}
+
+	infos, err := store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	wantInfos := []TokenInfo{
+		{ID: 0, Type: "systemd-tpm2", Size: len(`{"type":"systemd-tpm2","keyslots":["0"]}`)},
+		{ID: 1, Type: InitTokenType, Size: len(`{"type":"tdx-init","keyslots":[],"user_data":{"version":"2","phase":"complete"}}`)},
+		{ID: 2, Type: SSHTokenType, Size: len(`{"type":"ssh-key","keyslots":[],"user_data":{"ssh_key":"ssh-ed25519 AAAA"}}`)},
+	}
+	if !reflect.DeepEqual(infos, wantInfos) {
+		t.Errorf("List() = %+v, want %+v", infos, wantInfos)
+	}
+
+	key, err := GetSSHToken(path)
+	if err != nil || key != "ssh-ed25519 AAAA" {
+		t.Errorf("GetSSHToken() = %q, %v", key, err)
+	}
+
+	var data map[string]string
+	if err := store.Get(IntegrityTokenType, &data); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Get(%s) error = %v, want %v", IntegrityTokenType, err, ErrTokenNotFound)
+	}
+
+	// Rejected before cryptsetup is run
+	large := map[string]string{"data": strings.Repeat("x", hdrSize)}
+	if err := store.Put("custom", large); err == nil || !strings.Contains(err.Error(), "does not fit") {
+		t.Errorf("Put() of %d bytes error = %v, want size error", hdrSize, err)
+	}
+}
@@ -0,0 +1,122 @@
+package disks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"syscall"
+	"tdx-init/pkg/config"
+	"time"
+)
+
+const netlinkKobjectUevent = 15
+
+// waitForDevice calls find until it succeeds, the configured timeout passes
+// or ctx is cancelled. Between attempts udev is given the chance to finish
+// creating device nodes and links, which the finders rely on. Without a
+// timeout find is called once.
+func waitForDevice(ctx context.Context, wait config.WaitConfig, diskName string, find func() (string, error)) (string, error) {
+	device, err := find()
+	if err == nil || wait.Timeout == 0 {
+		return device, err
+	}
+
+	log.Printf("No device for disk %s yet (%v), waiting up to %s", diskName, err, wait.Timeout)
+
+	waitCtx, cancel := context.WithTimeout(ctx, wait.Timeout)
+	defer cancel()
+
+	var events <-chan struct{}
+	if wait.Mode == "uevent" {
+		ch, werr := watchBlockUevents(waitCtx)
+		if werr != nil {
+			log.Printf("Warning: Failed to watch uevents, polling instead: %v", werr)
+		} else {
+			events = ch
+		}
+	}
+
+	// Uevents trigger an immediate retry; the ticker still covers events
+	// that were missed or did not change the outcome
+	ticker := time.NewTicker(wait.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "", fmt.Errorf("no device appeared within %s: %w", wait.Timeout, err)
+		case <-ticker.C:
+		case <-events:
+		}
+
+		exec.CommandContext(waitCtx, "udevadm", "settle").Run()
+
+		if device, err = find(); err == nil {
+			log.Printf("Found device %s for disk %s", device, diskName)
+			return device, nil
+		}
+	}
+}
+
+// watchBlockUevents listens for kernel uevents and signals on the returned
+// channel whenever a block device is added or changed, until ctx is done.
+func watchBlockUevents(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, netlinkKobjectUevent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uevent socket: %w", err)
+	}
+
+	addr := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to bind uevent socket: %w", err)
+	}
+
+	// A receive timeout lets the reader notice cancellation
+	timeout := syscall.NsecToTimeval(int64(500 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to configure uevent socket: %w", err)
+	}
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 16384)
+		for ctx.Err() == nil {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil || n <= 0 {
+				continue
+			}
+			if !isBlockUevent(buf[:n]) {
+				continue
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// isBlockUevent checks a kernel uevent message, a header followed by
+// NUL-separated KEY=value pairs, for an added or changed block device.
+func isBlockUevent(msg []byte) bool {
+	block, relevant := false, false
+	for _, field := range bytes.Split(msg, []byte{0}) {
+		switch string(field) {
+		case "SUBSYSTEM=block":
+			block = true
+		case "ACTION=add", "ACTION=change":
+			relevant = true
+		}
+	}
+	return block && relevant
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"syscall"
 	"tdx-init/pkg/tpm"
 )
@@ -14,6 +15,10 @@ type PipeProvider struct {
 	UseTPM     bool
 	tpmStorage *tpm.TPMStorage
 	cachedKey  string
+
+	// mu serializes Get and Store. Concurrent callers wait for the one
+	// reading the pipe and then get the cached key.
+	mu sync.Mutex
 }
 
 func NewPipeProvider(pipePath string, useTPM bool) *PipeProvider {
@@ -25,6 +30,9 @@ func NewPipeProvider(pipePath string, useTPM bool) *PipeProvider {
 }
 
 func (p *PipeProvider) Get(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if p.UseTPM && p.tpmStorage.Available() {
 		key, err := p.tpmStorage.Retrieve()
 		if err == nil && key != "" {
@@ -82,6 +90,9 @@ func (p *PipeProvider) Get(ctx context.Context) (string, error) {
 }
 
 func (p *PipeProvider) Store(key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	p.cachedKey = key
 	if p.UseTPM && p.tpmStorage.Available() {
 		return p.tpmStorage.Store(key)
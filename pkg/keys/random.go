@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"tdx-init/pkg/tpm"
 )
 
@@ -15,6 +16,10 @@ type RandomProvider struct {
 	UseTPM     bool
 	tpmStorage *tpm.TPMStorage
 	cachedKey  string
+
+	// mu serializes Get and Store, so that disks set up concurrently with
+	// the same key share one key instead of generating or reading several
+	mu sync.Mutex
 }
 
 func NewRandomProvider(size int, useTPM bool) *RandomProvider {
@@ -26,6 +31,9 @@ func NewRandomProvider(size int, useTPM bool) *RandomProvider {
 }
 
 func (r *RandomProvider) Get(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	if r.UseTPM && r.tpmStorage.Available() {
 		key, err := r.tpmStorage.Retrieve()
 		if err == nil && key != "" {
@@ -57,6 +65,9 @@ func (r *RandomProvider) Get(ctx context.Context) (string, error) {
 }
 
 func (r *RandomProvider) Store(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.cachedKey = key
 	if r.UseTPM && r.tpmStorage.Available() {
 		return r.tpmStorage.Store(key)
@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
 	"tdx-init/pkg/config"
 	"tdx-init/pkg/disks"
 	"tdx-init/pkg/keys"
 	"tdx-init/pkg/ssh"
+	"time"
 )
 
 type Orchestrator struct {
@@ -41,15 +43,42 @@ func NewOrchestrator(cfg *config.Config) (*Orchestrator, error) {
 	}, nil
 }
 
-func (o *Orchestrator) Setup(ctx context.Context) error {
+func (o *Orchestrator) Setup(ctx context.Context) (err error) {
 	log.Println("Starting TDX initialization...")
 
-	disksToSetup := o.getDisksInOrder()
-	
+	defer func() {
+		if statusErr := o.writeStatus(err); statusErr != nil {
+			log.Printf("Warning: Failed to write status: %v", statusErr)
+		}
+	}()
+
+	disksToSetup, err := o.getDisksInOrder()
+	if err != nil {
+		return err
+	}
+
+	log.Println("Resolving disk devices...")
+	if err := o.diskManager.ResolveDisks(ctx, disksToSetup); err != nil {
+		return fmt.Errorf("failed to resolve disk devices: %w", err)
+	}
+
+	if err := o.setupDisks(ctx, disksToSetup); err != nil {
+		return err
+	}
+
+	// overlayfs does not show what is mounted below its lower directory, so
+	// the overlays go first and persisted directories are bound over them
+	log.Println("Setting up overlays...")
+	for _, overlay := range o.config.Overlays {
+		if err := o.diskManager.SetupOverlay(overlay); err != nil {
+			return fmt.Errorf("failed to setup overlay %s: %w", overlay.Target, err)
+		}
+	}
+
+	log.Println("Setting up persistent directories...")
 	for _, diskName := range disksToSetup {
-		log.Printf("Setting up disk: %s", diskName)
-		if err := o.diskManager.SetupDisk(ctx, diskName); err != nil {
-			return fmt.Errorf("failed to setup disk %s: %w", diskName, err)
+		if err := o.diskManager.SetupPersist(diskName); err != nil {
+			return err
 		}
 	}
 
@@ -62,18 +91,154 @@ func (o *Orchestrator) Setup(ctx context.Context) error {
 	return nil
 }
 
-func (o *Orchestrator) getDisksInOrder() []string {
-	var order []string
-	
-	if o.config.SSH.StoreAt != "" {
-		order = append(order, o.config.SSH.StoreAt)
+type diskResult struct {
+	name    string
+	err     error
+	elapsed time.Duration
+}
+
+// setupDisks sets up disks concurrently, up to setup.concurrency at a time.
+// A disk starts once every disk it depends on is ready; among ready disks
+// the setup order decides. When a disk fails, the context of the others is
+// cancelled and every disk that was started is torn down again.
+func (o *Orchestrator) setupDisks(ctx context.Context, order []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	deps := o.config.DiskDependencies()
+	position := make(map[string]int)
+	pending := make(map[string]int)
+	dependents := make(map[string][]string)
+	var ready []string
+	for i, name := range order {
+		position[name] = i
+		pending[name] = len(deps[name])
+		for _, dep := range deps[name] {
+			dependents[dep] = append(dependents[dep], name)
+		}
+		if pending[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	results := make(chan diskResult)
+	var started []string
+	var setupErr error
+	running, done := 0, 0
+	for done < len(order) {
+		for setupErr == nil && running < o.config.Setup.Concurrency && len(ready) > 0 {
+			name := ready[0]
+			ready = ready[1:]
+			started = append(started, name)
+			running++
+
+			go func(name string) {
+				log.Printf("Setting up disk: %s", name)
+				start := time.Now()
+				err := o.diskManager.SetupDisk(ctx, name)
+				results <- diskResult{name: name, err: err, elapsed: time.Since(start)}
+			}(name)
+		}
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		done++
+
+		if result.err != nil {
+			if setupErr == nil {
+				setupErr = fmt.Errorf("failed to setup disk %s: %w", result.name, result.err)
+				cancel()
+			} else {
+				log.Printf("Warning: Disk %s also failed: %v", result.name, result.err)
+			}
+			continue
+		}
+
+		log.Printf("Disk %s ready after %s (%d/%d)", result.name, result.elapsed.Round(time.Second), done, len(order))
+		for _, dependent := range dependents[result.name] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+		sort.Slice(ready, func(i, j int) bool { return position[ready[i]] < position[ready[j]] })
+	}
+
+	if setupErr != nil {
+		log.Println("Cleaning up disks after failed setup...")
+		for i := len(started) - 1; i >= 0; i-- {
+			if err := o.diskManager.TeardownDisk(started[i]); err != nil {
+				log.Printf("Warning: Failed to clean up disk %s: %v", started[i], err)
+			}
+		}
+		// Disks that never started still hold the loop devices attached
+		// while resolving them
+		wasStarted := make(map[string]bool)
+		for _, name := range started {
+			wasStarted[name] = true
+		}
+		for _, name := range order {
+			if wasStarted[name] {
+				continue
+			}
+			if err := o.diskManager.ReleaseDisk(name); err != nil {
+				log.Printf("Warning: Failed to release disk %s: %v", name, err)
+			}
+		}
+		return setupErr
+	}
+
+	return nil
+}
+
+// Teardown releases every disk in the reverse of the setup order. It keeps
+// going after a failure so that as much as possible is closed at shutdown.
+func (o *Orchestrator) Teardown() error {
+	log.Println("Starting TDX teardown...")
+
+	disks, err := o.getDisksInOrder()
+	if err != nil {
+		return err
+	}
+
+	// Persisted directories may be bound inside overlay targets
+	var failed []string
+	for i := len(disks) - 1; i >= 0; i-- {
+		if err := o.diskManager.TeardownPersist(disks[i]); err != nil {
+			log.Printf("Warning: %v", err)
+			failed = append(failed, disks[i])
+		}
+	}
+	for i := len(o.config.Overlays) - 1; i >= 0; i-- {
+		overlay := o.config.Overlays[i]
+		if err := o.diskManager.TeardownOverlay(overlay); err != nil {
+			log.Printf("Warning: %v", err)
+			failed = append(failed, overlay.Target)
+		}
 	}
 
-	for name := range o.config.Disks {
-		if name != o.config.SSH.StoreAt {
-			order = append(order, name)
+	for i := len(disks) - 1; i >= 0; i-- {
+		if err := o.diskManager.TeardownDisk(disks[i]); err != nil {
+			log.Printf("Warning: Failed to tear down disk %s: %v", disks[i], err)
+			failed = append(failed, disks[i])
 		}
 	}
 
-	return order
-}
\ No newline at end of file
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to tear down: %v", failed)
+	}
+
+	log.Println("TDX teardown completed successfully")
+	return nil
+}
+
+func (o *Orchestrator) getDisksInOrder() ([]string, error) {
+	order, err := o.config.DiskOrder()
+	if err != nil {
+		return nil, fmt.Errorf("failed to order disks: %w", err)
+	}
+	return order, nil
+}
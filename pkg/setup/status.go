@@ -0,0 +1,57 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"tdx-init/pkg/disks"
+	"time"
+)
+
+// StatusFile is where the outcome of the last setup run is recorded for the
+// status command. It lives on tmpfs, so it describes the current boot only.
+const StatusFile = "/run/tdx-init/status.json"
+
+type Status struct {
+	UpdatedAt time.Time          `json:"updated_at"`
+	Error     string             `json:"error,omitempty"`
+	Disks     []disks.DiskStatus `json:"disks"`
+}
+
+func (o *Orchestrator) writeStatus(setupErr error) error {
+	status := Status{
+		UpdatedAt: time.Now().UTC(),
+		Disks:     o.diskManager.Status(),
+	}
+	if setupErr != nil {
+		status.Error = setupErr.Error()
+	}
+
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal status: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(StatusFile), 0755); err != nil {
+		return fmt.Errorf("failed to create status directory: %w", err)
+	}
+	tmpPath := StatusFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write status: %w", err)
+	}
+	return os.Rename(tmpPath, StatusFile)
+}
+
+func ReadStatus() (*Status, error) {
+	data, err := os.ReadFile(StatusFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status: %w", err)
+	}
+
+	var status Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse status: %w", err)
+	}
+	return &status, nil
+}
@@ -71,11 +71,12 @@ func (sm *Manager) tryGetStoredKey() (string, error) {
 		return "", fmt.Errorf("disk %s not found", sm.config.StoreAt)
 	}
 
-	if disk.DevicePath == "" {
+	device := disk.MetadataDevice()
+	if device == "" {
 		return "", fmt.Errorf("disk %s not yet initialized", sm.config.StoreAt)
 	}
 
-	key, err := disks.GetSSHToken(disk.DevicePath)
+	key, err := disks.GetSSHToken(device)
 	if err != nil {
 		return "", err
 	}
@@ -90,11 +91,12 @@ func (sm *Manager) storeKeyInDisk(sshKey string) error {
 		return fmt.Errorf("disk %s not found", sm.config.StoreAt)
 	}
 
-	if disk.DevicePath == "" {
+	device := disk.MetadataDevice()
+	if device == "" {
 		return fmt.Errorf("disk %s not yet initialized", sm.config.StoreAt)
 	}
 
-	if err := disks.StoreSSHToken(disk.DevicePath, sshKey); err != nil {
+	if err := disks.StoreSSHToken(device, sshKey); err != nil {
 		return fmt.Errorf("failed to store SSH token: %w", err)
 	}
 
@@ -0,0 +1,6 @@
+// Package version holds the version of tdx-init, which is recorded in the
+// LUKS tokens it writes. Release builds set it with
+// -ldflags "-X tdx-init/pkg/version.Version=v1.2.3".
+package version
+
+var Version = "dev"